@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"gophermart/internal/accrualmock"
+)
+
+func main() {
+
+	var address, scriptPath string
+
+	flag.StringVar(&address,
+		"a", "127.0.0.1:8081",
+		"Адрес, на котором располагается заглушка системы расчета",
+	)
+	flag.StringVar(&scriptPath,
+		"s", "",
+		"Файл со сценарием ответов в формате JSON",
+	)
+	flag.Parse()
+
+	script := accrualmock.DefaultScript()
+	if scriptPath != "" {
+		var err error
+
+		script, err = accrualmock.LoadScript(scriptPath)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+
+	server := accrualmock.NewServer(script)
+
+	log.Printf("заглушка системы расчета слушает %s", address)
+	log.Println(http.ListenAndServe(address, server.Router()))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/database"
+	"gophermart/internal/storage"
+)
+
+var (
+	ErrLedgerUsage = errors.New("использование: gophermart [флаги] ledger show <логин> [-at <время>]|reverse <id>|adjust <логин> <сумма>")
+)
+
+func runLedger(cfg *config.Config, args []string) error {
+
+	if len(args) < 2 {
+		return ErrLedgerUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db, err := database.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "show":
+		return showLedger(ctx, db, args[1], args[2:])
+
+	case "reverse":
+		if len(args) != 2 {
+			return ErrLedgerUsage
+		}
+
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return ErrLedgerUsage
+		}
+
+		return db.ReverseLedgerEntryWithContext(ctx, id)
+
+	case "adjust":
+		if len(args) != 3 {
+			return ErrLedgerUsage
+		}
+
+		amount, err := storage.ParsePoints(args[2])
+		if err != nil {
+			return err
+		}
+
+		return db.InsertLedgerEntryWithContext(ctx, &storage.LedgerEntry{
+			User:   args[1],
+			Kind:   storage.LedgerAdjustment,
+			Amount: amount,
+		})
+
+	default:
+		return ErrLedgerUsage
+	}
+}
+
+// showLedger печатает проводки пользователя и баланс на момент -at, по умолчанию - на сейчас.
+func showLedger(ctx context.Context, db database.Repository, login string, args []string) error {
+
+	flags := flag.NewFlagSet("ledger show", flag.ContinueOnError)
+	at := flags.String("at", "", "Момент, на который считается баланс: RFC 3339 или YYYY-MM-DD")
+	if err := flags.Parse(args); err != nil {
+		return ErrLedgerUsage
+	}
+
+	moment := time.Now()
+	if *at != "" {
+		var err error
+
+		moment, err = time.Parse(time.RFC3339, *at)
+		if err != nil {
+			moment, err = time.Parse("2006-01-02", *at)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: неверное время %q", ErrLedgerUsage, *at)
+		}
+	}
+
+	entries, err := db.GetLedger(ctx, login)
+	if err != nil {
+		return err
+	}
+
+	balance, withdrawn, err := db.GetBallAt(ctx, login, moment)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCREATED AT\tKIND\tAMOUNT\tORDER\tREF")
+	for _, entry := range entries {
+		createdAt, err := time.Parse(time.RFC3339, entry.CreatedAt)
+		if err == nil && createdAt.After(moment) {
+			continue
+		}
+
+		ref := ""
+		if entry.RefID != 0 {
+			ref = strconv.Itoa(entry.RefID)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", entry.ID, entry.CreatedAt, entry.Kind, entry.Amount, entry.Order, ref)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("баланс на %s: %s, списано: %s\n", moment.Format(time.RFC3339), balance, withdrawn)
+	return nil
+}
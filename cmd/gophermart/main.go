@@ -1,8 +1,14 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"gophermart/internal/client"
 	"gophermart/internal/config"
@@ -11,32 +17,99 @@ import (
 	"gophermart/internal/server/handlers"
 	"gophermart/internal/server/middleware"
 	"gophermart/internal/server/router"
+	"gophermart/internal/tokens"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 
-	cfg := config.NewConfig()
+	cfg, err := config.NewConfig()
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			if err := runMigrate(cfg, args[1:]); err != nil {
+				log.Fatalf("%s", err)
+			}
+			return
+		case "orders":
+			if err := runOrders(cfg, args[1:]); err != nil {
+				log.Fatalf("%s", err)
+			}
+			return
+		case "ledger":
+			if err := runLedger(cfg, args[1:]); err != nil {
+				log.Fatalf("%s", err)
+			}
+			return
+		default:
+			log.Fatalf("неизвестная команда %q", args[0])
+		}
+	}
+
+	db, err := database.New(cfg)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	db, err := database.NewUserDB(cfg)
+	clientManager, err := client.NewClient(cfg, db)
 	if err != nil {
 		log.Fatalf("%s", err)
 	}
 
-	clientManager := client.NewClient(cfg, db)
+	clientDone := make(chan struct{})
 	go func() {
-		err = clientManager.Run()
-		if err != nil {
-			log.Printf("%s: %s", client.ErrBlackBox, err)
-		}
+		defer close(clientDone)
+		clientManager.Run(ctx)
 	}()
 
-	cookie := cookies.NewCookieManager(cfg.SecretCookieKey, db)
+	sessions := database.NewSessionStore(cfg, db)
+
+	keys, err := cookies.NewKeyRing(cfg)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	cookie := cookies.NewCookieManager(keys, db, sessions, cfg.SessionTTL)
+
+	tokenManager, err := tokens.NewManager(cfg, sessions)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
 
-	handler := handlers.NewHandler(db, cookie, cfg)
+	handler := handlers.NewHandler(db, cookie, tokenManager, clientManager, cfg)
 
-	middle := middleware.NewMiddleware(cookie)
+	middle := middleware.NewMiddleware(cookie, tokenManager)
 
 	myRouter := router.NewRouter(handler, middle)
 
-	log.Println(http.ListenAndServe(cfg.Address, myRouter))
+	server := &http.Server{
+		Addr:    cfg.Address,
+		Handler: myRouter,
+	}
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("ошибка остановки сервера: %s", err)
+		}
+	}()
+
+	if err = server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Println(err)
+		stop()
+	}
+
+	<-clientDone
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/database"
+)
+
+var (
+	ErrMigrateUsage  = errors.New("использование: gophermart [флаги] migrate up|down [n]|status")
+	ErrMigrateMemory = errors.New("хранилище в памяти не требует миграций")
+)
+
+func runMigrate(cfg *config.Config, args []string) error {
+
+	if len(args) == 0 {
+		return ErrMigrateUsage
+	}
+
+	if database.IsMemory(cfg) {
+		return ErrMigrateMemory
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("схема базы данных актуальна")
+		}
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return ErrMigrateUsage
+			}
+		}
+
+		_, err = migrator.Down(ctx, steps)
+		return err
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+
+	default:
+		return ErrMigrateUsage
+	}
+}
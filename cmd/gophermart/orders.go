@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/database"
+)
+
+var (
+	ErrOrdersUsage = errors.New("использование: gophermart [флаги] orders stalled|requeue <номер>")
+)
+
+func runOrders(cfg *config.Config, args []string) error {
+
+	if len(args) == 0 {
+		return ErrOrdersUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	db, err := database.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "stalled":
+		orders, err := db.GetStalledOrders(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NUMBER\tUSER\tSTATUS\tATTEMPTS\tLAST ERROR")
+		for _, order := range orders {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", order.Number, order.User, order.Status, order.Attempts, order.LastError)
+		}
+		return w.Flush()
+
+	case "requeue":
+		if len(args) != 2 {
+			return ErrOrdersUsage
+		}
+
+		return db.RequeueOrder(ctx, args[1])
+
+	default:
+		return ErrOrdersUsage
+	}
+}
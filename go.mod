@@ -3,13 +3,16 @@ module gophermart
 go 1.19
 
 require (
-	github.com/caarlos0/env/v6 v6.10.1 // indirect
-	github.com/go-chi/chi/v5 v5.0.8 // indirect
-	github.com/gorilla/context v1.1.1 // indirect
+	github.com/caarlos0/env/v6 v6.10.1
+	github.com/go-chi/chi/v5 v5.0.8
+	github.com/gorilla/context v1.1.1
+	github.com/jackc/pgx/v5 v5.3.0
+	golang.org/x/crypto v0.6.0
+)
+
+require (
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
-	github.com/jackc/pgx/v5 v5.3.0 // indirect
-	github.com/theplant/luhn v0.0.0-20170224032821-81a1a381387a // indirect
-	golang.org/x/crypto v0.6.0 // indirect
+	golang.org/x/sys v0.5.0 // indirect
 	golang.org/x/text v0.7.0 // indirect
 )
@@ -0,0 +1,196 @@
+package accrualmock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"gophermart/internal/storage"
+)
+
+var (
+	ErrScript = errors.New("неверный сценарий заглушки системы расчета")
+)
+
+// Step - один ответ заглушки. Code по умолчанию 200, для 200 в ответ попадают
+// Status и Accrual, для 429 - заголовок Retry-After и лимит Rate в теле.
+type Step struct {
+	Code       int             `json:"code,omitempty"`
+	Status     string          `json:"status,omitempty"`
+	Accrual    *storage.Points `json:"accrual,omitempty"`
+	RetryAfter int             `json:"retry_after,omitempty"`
+	Rate       int             `json:"rate,omitempty"`
+	Latency    Duration        `json:"latency,omitempty"`
+}
+
+// Script задает ответы по номеру заказа, затем по префиксу номера, иначе Default.
+// Каждый запрос по заказу сдвигает его на следующий шаг, последний шаг повторяется.
+type Script struct {
+	Default  []Step            `json:"default"`
+	Prefixes map[string][]Step `json:"prefixes,omitempty"`
+	Orders   map[string][]Step `json:"orders,omitempty"`
+	Latency  Duration          `json:"latency,omitempty"`
+}
+
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("%w: длительность задается строкой вида \"150ms\"", ErrScript)
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrScript, err)
+	}
+
+	d.Duration = v
+	return nil
+}
+
+func DefaultScript() *Script {
+	accrual := storage.NewPoints(500, 0)
+
+	return &Script{
+		Default: []Step{
+			{Status: storage.AccrualRegistered},
+			{Status: storage.AccrualProcessing},
+			{Status: storage.AccrualProcessed, Accrual: &accrual},
+		},
+	}
+}
+
+func LoadScript(path string) (*Script, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var script Script
+	if err = json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrScript, err)
+	}
+
+	if len(script.Default) == 0 {
+		script.Default = DefaultScript().Default
+	}
+
+	return &script, nil
+}
+
+type Server struct {
+	script *Script
+
+	mu       sync.Mutex
+	progress map[string]int
+}
+
+func NewServer(script *Script) *Server {
+	return &Server{
+		script:   script,
+		progress: make(map[string]int),
+	}
+}
+
+func (s *Server) Router() chi.Router {
+	r := chi.NewRouter()
+	r.Get("/api/orders/{number}", s.Order)
+
+	return r
+}
+
+func (s *Server) Order(w http.ResponseWriter, r *http.Request) {
+
+	number := chi.URLParam(r, "number")
+	step := s.next(number)
+
+	if delay := s.script.Latency.Duration + step.Latency.Duration; delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	code := step.Code
+	if code == 0 {
+		code = http.StatusOK
+	}
+
+	switch code {
+	case http.StatusOK:
+		body, err := json.Marshal(struct {
+			Order   string          `json:"order"`
+			Status  string          `json:"status"`
+			Accrual *storage.Points `json:"accrual,omitempty"`
+		}{
+			Order:   number,
+			Status:  step.Status,
+			Accrual: step.Accrual,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+
+	case http.StatusTooManyRequests:
+		w.Header().Set("Retry-After", strconv.Itoa(step.RetryAfter))
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTooManyRequests)
+		if step.Rate > 0 {
+			fmt.Fprintf(w, "No more than %d requests per minute allowed", step.Rate)
+		}
+
+	default:
+		w.WriteHeader(code)
+	}
+}
+
+func (s *Server) next(number string) Step {
+	steps := s.steps(number)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.progress[number]
+	if i >= len(steps) {
+		i = len(steps) - 1
+	} else {
+		s.progress[number] = i + 1
+	}
+
+	return steps[i]
+}
+
+func (s *Server) steps(number string) []Step {
+	if steps, ok := s.script.Orders[number]; ok && len(steps) > 0 {
+		return steps
+	}
+
+	best := ""
+	for prefix := range s.script.Prefixes {
+		if strings.HasPrefix(number, prefix) && len(prefix) > len(best) && len(s.script.Prefixes[prefix]) > 0 {
+			best = prefix
+		}
+	}
+	if best != "" {
+		return s.script.Prefixes[best]
+	}
+
+	return s.script.Default
+}
@@ -0,0 +1,107 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+var breakerRank = map[string]int{
+	StateClosed:   0,
+	StateHalfOpen: 1,
+	StateOpen:     2,
+}
+
+var (
+	ErrCircuitOpen = errors.New("система расчета недоступна, запросы временно приостановлены")
+)
+
+// breaker размыкается после threshold ошибок подряд. Через cooldown пропускается
+// один пробный запрос: успех замыкает цепь, ошибка снова размыкает ее.
+type breaker struct {
+	mu        sync.Mutex
+	state     string
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	probing   bool
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{
+		state:     StateClosed,
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (b *breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = StateHalfOpen
+		b.probing = true
+		return nil
+	case StateHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = StateClosed
+	b.failures = 0
+	b.probing = false
+}
+
+func (b *breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.probing = false
+
+	if b.state == StateHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = StateOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Cancel освобождает пробный запрос, прерванный не по вине системы расчета.
+func (b *breaker) Cancel() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.cooldown {
+		return StateHalfOpen
+	}
+
+	return b.state
+}
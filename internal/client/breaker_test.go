@@ -0,0 +1,105 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	const cooldown = 20 * time.Millisecond
+
+	type step struct {
+		do    string
+		err   error
+		state string
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "размыкается после порога ошибок подряд",
+			steps: []step{
+				{do: "allow", state: StateClosed},
+				{do: "failure", state: StateClosed},
+				{do: "allow", state: StateClosed},
+				{do: "failure", state: StateOpen},
+				{do: "allow", err: ErrCircuitOpen, state: StateOpen},
+			},
+		},
+		{
+			name: "успех сбрасывает счетчик ошибок",
+			steps: []step{
+				{do: "failure", state: StateClosed},
+				{do: "success", state: StateClosed},
+				{do: "failure", state: StateClosed},
+			},
+		},
+		{
+			name: "после паузы пропускает один пробный запрос",
+			steps: []step{
+				{do: "failure"},
+				{do: "failure", state: StateOpen},
+				{do: "wait", state: StateHalfOpen},
+				{do: "allow", state: StateHalfOpen},
+				{do: "allow", err: ErrCircuitOpen, state: StateHalfOpen},
+				{do: "success", state: StateClosed},
+				{do: "allow", state: StateClosed},
+			},
+		},
+		{
+			name: "ошибка пробного запроса снова размыкает",
+			steps: []step{
+				{do: "failure"},
+				{do: "failure"},
+				{do: "wait"},
+				{do: "allow"},
+				{do: "failure", state: StateOpen},
+				{do: "allow", err: ErrCircuitOpen, state: StateOpen},
+			},
+		},
+		{
+			name: "отмененный пробный запрос не размыкает",
+			steps: []step{
+				{do: "failure"},
+				{do: "failure"},
+				{do: "wait"},
+				{do: "allow"},
+				{do: "cancel", state: StateHalfOpen},
+				{do: "allow", state: StateHalfOpen},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(2, cooldown)
+
+			for i, s := range tt.steps {
+				var err error
+
+				switch s.do {
+				case "allow":
+					err = b.Allow()
+				case "failure":
+					b.Failure()
+				case "success":
+					b.Success()
+				case "cancel":
+					b.Cancel()
+				case "wait":
+					time.Sleep(cooldown + 5*time.Millisecond)
+				}
+
+				if !errors.Is(err, s.err) {
+					t.Fatalf("шаг %d (%s): error = %v, want %v", i, s.do, err, s.err)
+				}
+				if s.state != "" && b.State() != s.state {
+					t.Fatalf("шаг %d (%s): state = %s, want %s", i, s.do, b.State(), s.state)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gophermart/internal/storage"
+)
+
+const (
+	SignatureHeader = "X-Accrual-Signature"
+	signaturePrefix = "sha256="
+)
+
+var (
+	ErrCallbackDisabled = errors.New("обратные вызовы системы расчета отключены")
+	ErrSignature        = errors.New("неверная подпись обратного вызова")
+	ErrCallbackFormat   = errors.New("неверный формат обратного вызова")
+)
+
+// Sign возвращает значение заголовка SignatureHeader для тела обратного вызова.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Callback применяет статус заказа, который система расчета прислала сама.
+// Тело совпадает с ответом GET /api/orders/{number} и подписано HMAC-SHA256
+// общим секретом, опрос при этом остается запасным путем.
+func (c *Client) Callback(ctx context.Context, body []byte, signature string) error {
+
+	if c.cfg.CallbackSecret == "" {
+		return ErrCallbackDisabled
+	}
+
+	if !strings.HasPrefix(signature, signaturePrefix) ||
+		!hmac.Equal([]byte(signature), []byte(Sign([]byte(c.cfg.CallbackSecret), body))) {
+		return ErrSignature
+	}
+
+	var resp Accrual
+
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("%w: %s", ErrCallbackFormat, err)
+	}
+	if resp.Order == "" {
+		return fmt.Errorf("%w: не указан номер заказа", ErrCallbackFormat)
+	}
+
+	order := storage.Order{Number: resp.Order}
+
+	return c.apply(ctx, &order, resp)
+}
@@ -2,12 +2,10 @@ package client
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
+	"math/rand"
+	"sync"
 	"time"
 
 	"gophermart/internal/config"
@@ -17,92 +15,256 @@ import (
 
 var (
 	ErrBlackBox = errors.New("ошибка обращения в систему расчета")
+
+	errNotRegistered = errors.New("заказ не зарегистрирован в системе расчета")
+	errThrottled     = errors.New("превышен лимит запросов к системе расчета")
 )
 
+type Stats struct {
+	Requests     int64
+	Failures     int64
+	Rejected     int64
+	BreakerState string
+}
+
 type Client struct {
-	cfg *config.Config
-	db  *database.UserDB
+	cfg       *config.Config
+	db        database.Repository
+	providers *providers
+
+	inFlight sync.Map
+
+	wake     chan struct{}
+	wakeMu   sync.Mutex
+	debounce *time.Timer
 }
 
-func NewClient(cfg *config.Config, db *database.UserDB) *Client {
+func NewClient(cfg *config.Config, db database.Repository) (*Client, error) {
+
+	set, err := newProviders(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Client{
-		cfg: cfg,
-		db:  db,
+		cfg:       cfg,
+		db:        db,
+		providers: set,
+		wake:      make(chan struct{}, 1),
+	}, nil
+}
+
+// Stats суммирует счетчики HTTP-провайдеров, состояние предохранителя - худшее из них.
+func (c *Client) Stats() Stats {
+	var total Stats
+
+	total.BreakerState = StateClosed
+
+	for _, p := range c.providers.http {
+		stats := p.Stats()
+
+		total.Requests += stats.Requests
+		total.Failures += stats.Failures
+		total.Rejected += stats.Rejected
+
+		if breakerRank[stats.BreakerState] > breakerRank[total.BreakerState] {
+			total.BreakerState = stats.BreakerState
+		}
+	}
+
+	return total
+}
+
+// Notify запускает внеочередной цикл опроса, если включен режим AccrualEager.
+// Загрузки за AccrualDebounce собираются в один цикл.
+func (c *Client) Notify() {
+	if !c.cfg.AccrualEager {
+		return
 	}
+
+	c.wakeMu.Lock()
+	defer c.wakeMu.Unlock()
+
+	if c.debounce != nil {
+		return
+	}
+
+	c.debounce = time.AfterFunc(c.cfg.AccrualDebounce, func() {
+		c.wakeMu.Lock()
+		c.debounce = nil
+		c.wakeMu.Unlock()
+
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	})
 }
 
-func (c *Client) Run() (err error) {
+// Run опрашивает систему расчета, пока не отменен ctx, и дожидается завершения воркеров.
+func (c *Client) Run(ctx context.Context) {
+
+	var wg sync.WaitGroup
+
+	queue := make(chan storage.Order, c.cfg.AccrualWorkers)
+
+	for i := 0; i < c.cfg.AccrualWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.worker(ctx, queue)
+		}()
+	}
+
+	ticker := time.NewTicker(c.cfg.PollInterval)
+	defer ticker.Stop()
+
 	for {
-		err = c.OrdersUpdater()
+		err := c.OrdersUpdater(ctx, queue)
 		if err != nil {
-			log.Printf("%s", err)
-			return err
+			log.Printf("%s: %s", ErrBlackBox, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			close(queue)
+			wg.Wait()
+			return
+		case <-ticker.C:
+		case <-c.wake:
 		}
 	}
 }
 
-func (c *Client) OrdersUpdater() error {
+func (c *Client) OrdersUpdater(ctx context.Context, queue chan<- storage.Order) error {
+
+	free := cap(queue) - len(queue)
+	if free <= 0 {
+		return nil
+	}
 
-	ctx := context.Background()
+	// заказы, которые еще у воркеров, в аренду не берем: снять ее было бы некому
+	var busy []string
+	c.inFlight.Range(func(number, _ interface{}) bool {
+		busy = append(busy, number.(string))
+		return true
+	})
 
-	orders, err := c.db.GetAllOrders(ctx)
+	orders, err := c.db.ClaimOrders(ctx, free, c.cfg.AccrualLease, busy)
 	if err != nil {
 		return err
 	}
 
 	for _, order := range orders {
-		switch order.Status {
-		case "PROCESSING":
-		case "NEW":
-			c.checkOrderStatus(&order)
-		default:
+		c.inFlight.Store(order.Number, struct{}{})
+
+		select {
+		case queue <- order:
+		case <-ctx.Done():
+			c.inFlight.Delete(order.Number)
+			c.release(order.Number, time.Now())
+			return nil
 		}
 	}
 	return nil
 }
 
-func (c *Client) checkOrderStatus(order *storage.Order) {
+func (c *Client) worker(ctx context.Context, queue <-chan storage.Order) {
+	for order := range queue {
+		order := order
 
-	var body []byte
+		if ctx.Err() != nil {
+			c.release(order.Number, time.Now())
+			c.inFlight.Delete(order.Number)
+			continue
+		}
 
-	ctx := context.Background()
-	addr, _ := url.JoinPath(c.cfg.BlackBox, "api", "orders", order.Number)
-	timer := time.NewTimer(0)
+		err := c.checkOrderStatus(ctx, &order)
+		switch {
+		case err == nil:
+			c.release(order.Number, time.Now().Add(c.cfg.PollInterval))
+		case errors.Is(err, errThrottled), ctx.Err() != nil:
+			c.release(order.Number, time.Now())
+		case errors.Is(err, ErrCircuitOpen):
+			c.release(order.Number, time.Now().Add(c.cfg.PollInterval))
+		default:
+			// в том числе errNotRegistered: незарегистрированный заказ со временем становится застрявшим
+			c.fail(&order, err)
+		}
 
-	select {
-	case <-timer.C:
+		c.inFlight.Delete(order.Number)
+	}
+}
 
-		r, err := http.Get(addr)
-		if err != nil {
-			return
-		}
+func (c *Client) fail(order *storage.Order, reason error) {
+	attempts := order.Attempts + 1
+	stalled := c.cfg.AccrualMaxAttempts > 0 && attempts >= c.cfg.AccrualMaxAttempts
+	next := time.Now().Add(c.backoff(attempts))
 
-		body, err = io.ReadAll(r.Body)
-		if err != nil {
-			return
-		}
+	if stalled {
+		log.Printf("%s: заказ %s остановлен после %d попыток: %s", ErrBlackBox, order.Number, attempts, reason)
+	} else {
+		log.Printf("%s: заказ %s, попытка %d: %s", ErrBlackBox, order.Number, attempts, reason)
+	}
 
-		err = json.Unmarshal(body, order)
-		if err != nil {
-			return
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 
-		switch r.StatusCode {
-		case http.StatusOK:
-			if err = c.db.SetStatus(ctx, order); err != nil {
-				return
-			}
-			return
-		case http.StatusNoContent:
-			return
-		case http.StatusTooManyRequests:
-			return
-		}
+	if err := c.db.FailOrder(ctx, order.Number, reason.Error(), next, stalled); err != nil {
+		log.Printf("%s: заказ %s: %s", ErrBlackBox, order.Number, err)
+	}
+}
 
-		_ = r.Body.Close()
-		return
+// backoff растет экспоненциально от AccrualBackoff до AccrualBackoffMax, а половина
+// задержки случайна, чтобы заказы, упавшие одновременно, не возвращались пачкой.
+func (c *Client) backoff(attempts int) time.Duration {
+	delay := c.cfg.AccrualBackoff
+	for i := 1; i < attempts && delay < c.cfg.AccrualBackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > c.cfg.AccrualBackoffMax {
+		delay = c.cfg.AccrualBackoffMax
+	}
 
-	default:
+	half := delay / 2
+	if half <= 0 {
+		return delay
 	}
 
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
+
+// release снимает аренду даже при остановке сервиса, чтобы заказ сразу
+// подхватил другой экземпляр, а не ждал истечения аренды.
+func (c *Client) release(number string, next time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := c.db.ReleaseOrder(ctx, number, next); err != nil {
+		log.Printf("%s: заказ %s: %s", ErrBlackBox, number, err)
+	}
+}
+
+func (c *Client) checkOrderStatus(ctx context.Context, order *storage.Order) error {
+
+	resp, err := c.providers.route(order.Number).Accrual(ctx, *order)
+	if err != nil {
+		return err
+	}
+
+	return c.apply(ctx, order, resp)
+}
+
+// apply переводит заказ в статус из ответа системы расчета и начисляет баллы.
+// Через него проходят и результаты опроса, и обратные вызовы.
+func (c *Client) apply(ctx context.Context, order *storage.Order, resp Accrual) error {
+	status, err := storage.FromAccrual(resp.Status)
+	if err != nil {
+		return err
+	}
+
+	order.Status = status
+	order.Accrual = resp.Accrual
+
+	return c.db.SetStatus(ctx, order)
 }
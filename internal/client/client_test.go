@@ -0,0 +1,130 @@
+package client_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gophermart/internal/accrualmock"
+	"gophermart/internal/client"
+	"gophermart/internal/config"
+	"gophermart/internal/database"
+	"gophermart/internal/storage"
+)
+
+// Заказ проходит REGISTERED -> PROCESSING -> PROCESSED по сценарию заглушки,
+// а баллы начисляются один раз, сколько бы раз ни пришел итоговый статус.
+func TestClientCreditsProcessedOrderOnce(t *testing.T) {
+	const (
+		login  = "user1"
+		number = "12345678903"
+		secret = "callback-secret"
+	)
+
+	srv := httptest.NewServer(accrualmock.NewServer(accrualmock.DefaultScript()).Router())
+	defer srv.Close()
+
+	cfg := &config.Config{
+		BlackBox:              srv.URL,
+		PasswdHash:            "bcrypt",
+		BcryptCost:            4,
+		AccrualWorkers:        2,
+		PollInterval:          10 * time.Millisecond,
+		AccrualLease:          time.Second,
+		AccrualBackoff:        10 * time.Millisecond,
+		AccrualBackoffMax:     50 * time.Millisecond,
+		AccrualConnectTimeout: time.Second,
+		AccrualTimeout:        time.Second,
+		BreakerThreshold:      5,
+		BreakerCooldown:       time.Second,
+		CallbackSecret:        secret,
+	}
+
+	db, err := database.NewMemoryDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err = db.InsertOrderWithContext(ctx, &storage.Order{
+		User:       login,
+		Number:     number,
+		Status:     storage.StatusNew,
+		UploadedAt: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.NewClient(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	runCtx, stop := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Run(runCtx)
+	}()
+
+	var detail storage.OrderDetail
+	for {
+		detail, err = db.GetUserOrder(ctx, login, number)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if detail.Status == storage.StatusProcessed {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			t.Fatalf("заказ не обработан, статус %s", detail.Status)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+
+	// еще несколько циклов опроса: обработанный заказ больше не опрашивается
+	time.Sleep(5 * cfg.PollInterval)
+	stop()
+	<-done
+
+	// повторный итоговый статус приходит обратным вызовом
+	body := []byte(`{"order":"` + number + `","status":"PROCESSED","accrual":500}`)
+	if err = c.Callback(ctx, body, client.Sign([]byte(secret), body)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{storage.StatusProcessing, storage.StatusProcessed}
+	if len(detail.History) != len(want) {
+		t.Fatalf("история = %+v, want переходы в %v", detail.History, want)
+	}
+	for i, event := range detail.History {
+		if event.To != want[i] {
+			t.Errorf("переход %d в %s, want %s", i, event.To, want[i])
+		}
+	}
+	if detail.History[0].From != storage.StatusNew {
+		t.Errorf("первый переход из %s, want %s", detail.History[0].From, storage.StatusNew)
+	}
+
+	balance, withdrawn, err := db.GetBall(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != storage.NewPoints(500, 0) || withdrawn != 0 {
+		t.Errorf("баланс = %s, списано = %s, want 500.00, 0.00", balance, withdrawn)
+	}
+
+	entries, err := db.GetLedger(ctx, login)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Kind != storage.LedgerAccrual {
+		t.Errorf("проводки = %+v, want одно начисление", entries)
+	}
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+)
+
+// HTTPProvider опрашивает внешнюю систему расчета по GET /api/orders/{number}.
+// Лимит запросов и предохранитель у каждого провайдера свои.
+type HTTPProvider struct {
+	name    string
+	address string
+	http    *http.Client
+	limiter *limiter
+	breaker *breaker
+
+	requests int64
+	failures int64
+	rejected int64
+}
+
+func NewHTTPProvider(name, address string, rateLimit int, cfg *config.Config) *HTTPProvider {
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout: cfg.AccrualConnectTimeout,
+		}).DialContext,
+		ResponseHeaderTimeout: cfg.AccrualTimeout,
+		MaxIdleConnsPerHost:   cfg.AccrualWorkers,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	return &HTTPProvider{
+		name:    name,
+		address: address,
+		http: &http.Client{
+			Transport: transport,
+			Timeout:   cfg.AccrualTimeout,
+		},
+		limiter: newLimiter(rateLimit),
+		breaker: newBreaker(cfg.BreakerThreshold, cfg.BreakerCooldown),
+	}
+}
+
+func (p *HTTPProvider) Name() string {
+	return p.name
+}
+
+func (p *HTTPProvider) Accrual(ctx context.Context, order storage.Order) (resp Accrual, err error) {
+
+	addr, err := url.JoinPath(p.address, "api", "orders", order.Number)
+	if err != nil {
+		return resp, err
+	}
+
+	if err = p.limiter.Wait(ctx); err != nil {
+		return resp, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, addr, nil)
+	if err != nil {
+		return resp, err
+	}
+
+	if err = p.breaker.Allow(); err != nil {
+		atomic.AddInt64(&p.rejected, 1)
+		return resp, err
+	}
+
+	atomic.AddInt64(&p.requests, 1)
+
+	r, err := p.http.Do(req)
+	if err != nil {
+		if ctx.Err() == nil {
+			atomic.AddInt64(&p.failures, 1)
+			p.breaker.Failure()
+		} else {
+			p.breaker.Cancel()
+		}
+		return resp, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode >= http.StatusInternalServerError {
+		atomic.AddInt64(&p.failures, 1)
+		p.breaker.Failure()
+	} else {
+		p.breaker.Success()
+	}
+
+	switch r.StatusCode {
+	case http.StatusOK:
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return resp, err
+		}
+
+		err = json.Unmarshal(body, &resp)
+		return resp, err
+	case http.StatusNoContent:
+		return resp, errNotRegistered
+	case http.StatusTooManyRequests:
+		pause := retryAfter(r.Header)
+		p.limiter.Pause(pause)
+
+		body, _ := io.ReadAll(r.Body)
+		if limit, ok := advertisedRate(body); ok {
+			p.limiter.SetRate(limit)
+		}
+
+		log.Printf("%s: %s: превышен лимит запросов, пауза %s", ErrBlackBox, p.name, pause)
+		return resp, errThrottled
+	default:
+		return resp, fmt.Errorf("неожиданный ответ %d", r.StatusCode)
+	}
+}
+
+func (p *HTTPProvider) Stats() Stats {
+	return Stats{
+		Requests:     atomic.LoadInt64(&p.requests),
+		Failures:     atomic.LoadInt64(&p.failures),
+		Rejected:     atomic.LoadInt64(&p.rejected),
+		BreakerState: p.breaker.State(),
+	}
+}
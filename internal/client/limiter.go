@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultRetryAfter = 60 * time.Second
+
+var rateLimitPattern = regexp.MustCompile(`No more than (\d+) requests per minute`)
+
+// limiter общий для всех воркеров: пауза после 429 останавливает их всех,
+// а интервал между запросами подстраивается под лимит, объявленный системой расчета.
+type limiter struct {
+	mu          sync.Mutex
+	pausedUntil time.Time
+	interval    time.Duration
+	next        time.Time
+}
+
+func newLimiter(perMinute int) *limiter {
+	l := &limiter{}
+	l.SetRate(perMinute)
+
+	return l
+}
+
+func (l *limiter) Wait(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+
+		start := now
+		if l.pausedUntil.After(start) {
+			start = l.pausedUntil
+		}
+		if l.next.After(start) {
+			start = l.next
+		}
+
+		if !start.After(now) {
+			if l.interval > 0 {
+				l.next = now.Add(l.interval)
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(start.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *limiter) Pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+func (l *limiter) SetRate(perMinute int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if perMinute <= 0 {
+		l.interval = 0
+		return
+	}
+
+	l.interval = time.Minute / time.Duration(perMinute)
+}
+
+func retryAfter(h http.Header) time.Duration {
+	value := h.Get("Retry-After")
+	if value == "" {
+		return defaultRetryAfter
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		return time.Until(at)
+	}
+
+	return defaultRetryAfter
+}
+
+func advertisedRate(body []byte) (int, bool) {
+	m := rateLimitPattern.FindSubmatch(body)
+	if m == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(string(m[1]))
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+
+	return n, true
+}
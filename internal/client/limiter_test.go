@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gophermart/internal/accrualmock"
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", defaultRetryAfter},
+		{"0", 0},
+		{"5", 5 * time.Second},
+		{"-1", defaultRetryAfter},
+		{"soon", defaultRetryAfter},
+	}
+
+	for _, tt := range tests {
+		h := http.Header{}
+		if tt.value != "" {
+			h.Set("Retry-After", tt.value)
+		}
+
+		if got := retryAfter(h); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+
+	h := http.Header{}
+	h.Set("Retry-After", time.Now().Add(30*time.Second).UTC().Format(http.TimeFormat))
+	if got := retryAfter(h); got < 28*time.Second || got > 30*time.Second {
+		t.Errorf("retryAfter(дата через 30s) = %s", got)
+	}
+}
+
+func TestAdvertisedRate(t *testing.T) {
+	tests := []struct {
+		body string
+		want int
+		ok   bool
+	}{
+		{"No more than 60 requests per minute allowed", 60, true},
+		{"No more than 0 requests per minute allowed", 0, false},
+		{"Too Many Requests", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := advertisedRate([]byte(tt.body))
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("advertisedRate(%q) = %d, %v, want %d, %v", tt.body, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestLimiterPause(t *testing.T) {
+	l := newLimiter(0)
+	l.Pause(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < 45*time.Millisecond {
+		t.Errorf("Wait после Pause(50ms) вернулся через %s", waited)
+	}
+
+	// пауза короче текущей ее не сокращает
+	l.Pause(time.Hour)
+	l.Pause(time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := l.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait во время паузы = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	l := newLimiter(6000)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// первый запрос сразу, следующие - через 10ms
+	if waited := time.Since(start); waited < 18*time.Millisecond {
+		t.Errorf("три запроса при лимите 6000 в минуту заняли %s", waited)
+	}
+}
+
+func TestHTTPProviderThrottled(t *testing.T) {
+	mock := accrualmock.NewServer(&accrualmock.Script{
+		Default: []accrualmock.Step{
+			{Code: http.StatusTooManyRequests, RetryAfter: 2, Rate: 30},
+			{Status: storage.AccrualRegistered},
+		},
+	})
+
+	srv := httptest.NewServer(mock.Router())
+	defer srv.Close()
+
+	cfg := &config.Config{
+		AccrualWorkers:        1,
+		AccrualConnectTimeout: time.Second,
+		AccrualTimeout:        time.Second,
+		BreakerThreshold:      1,
+		BreakerCooldown:       time.Minute,
+	}
+
+	p := NewHTTPProvider(defaultProvider, srv.URL, 0, cfg)
+
+	_, err := p.Accrual(context.Background(), storage.Order{Number: "12345678903"})
+	if !errors.Is(err, errThrottled) {
+		t.Fatalf("Accrual после 429 = %v, want %v", err, errThrottled)
+	}
+
+	l := p.limiter
+	l.mu.Lock()
+	paused := time.Until(l.pausedUntil)
+	interval := l.interval
+	l.mu.Unlock()
+
+	if paused < time.Second || paused > 2*time.Second {
+		t.Errorf("пауза после Retry-After: 2 = %s", paused)
+	}
+	if interval != 2*time.Second {
+		t.Errorf("интервал после лимита 30 в минуту = %s, want 2s", interval)
+	}
+
+	// 429 - не отказ системы расчета, предохранитель с порогом 1 остается замкнутым
+	if state := p.Stats().BreakerState; state != StateClosed {
+		t.Errorf("предохранитель после 429 = %s, want %s", state, StateClosed)
+	}
+}
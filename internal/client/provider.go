@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+)
+
+const (
+	ProviderHTTP  = "http"
+	ProviderRules = "rules"
+
+	defaultProvider = "default"
+)
+
+var (
+	ErrProviderConfig = errors.New("неверная конфигурация систем расчета")
+)
+
+// Accrual - ответ системы расчета по заказу.
+type Accrual struct {
+	Order   string         `json:"order"`
+	Status  string         `json:"status"`
+	Accrual storage.Points `json:"accrual"`
+}
+
+// AccrualProvider рассчитывает баллы за заказ. Ошибки errThrottled и ErrCircuitOpen
+// воркер обрабатывает без наращивания попыток, остальные, включая errNotRegistered,
+// считаются неудачной попыткой.
+type AccrualProvider interface {
+	Name() string
+	Accrual(ctx context.Context, order storage.Order) (Accrual, error)
+}
+
+type ProviderConfig struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+
+	Address   string `json:"address,omitempty"`
+	RateLimit int    `json:"rate_limit,omitempty"`
+
+	Percent storage.Points `json:"percent,omitempty"`
+	Bonus   storage.Points `json:"bonus,omitempty"`
+}
+
+// ProvidersConfig - файл ACCRUAL_PROVIDERS_FILE. Заказ уходит в провайдер
+// с самым длинным подходящим префиксом номера из Routes, иначе в Default.
+type ProvidersConfig struct {
+	Default   string            `json:"default"`
+	Providers []ProviderConfig  `json:"providers"`
+	Routes    map[string]string `json:"routes,omitempty"`
+}
+
+type providers struct {
+	fallback AccrualProvider
+	routes   map[string]AccrualProvider
+	http     []*HTTPProvider
+}
+
+func newProviders(cfg *config.Config) (*providers, error) {
+
+	if cfg.AccrualProvidersFile == "" {
+		p := NewHTTPProvider(defaultProvider, cfg.BlackBox, cfg.AccrualRateLimit, cfg)
+
+		return &providers{
+			fallback: p,
+			http:     []*HTTPProvider{p},
+		}, nil
+	}
+
+	data, err := os.ReadFile(cfg.AccrualProvidersFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var file ProvidersConfig
+
+	if err = json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrProviderConfig, err)
+	}
+
+	set := &providers{routes: make(map[string]AccrualProvider)}
+	byName := make(map[string]AccrualProvider)
+
+	for _, pc := range file.Providers {
+		if pc.Name == "" {
+			return nil, fmt.Errorf("%w: у провайдера нет имени", ErrProviderConfig)
+		}
+		if _, ok := byName[pc.Name]; ok {
+			return nil, fmt.Errorf("%w: провайдер %q объявлен дважды", ErrProviderConfig, pc.Name)
+		}
+
+		switch pc.Type {
+		case ProviderHTTP, "":
+			address := pc.Address
+			if address == "" {
+				address = cfg.BlackBox
+			}
+
+			rate := pc.RateLimit
+			if rate == 0 {
+				rate = cfg.AccrualRateLimit
+			}
+
+			p := NewHTTPProvider(pc.Name, address, rate, cfg)
+			set.http = append(set.http, p)
+			byName[pc.Name] = p
+		case ProviderRules:
+			byName[pc.Name] = NewRulesProvider(pc.Name, pc.Percent, pc.Bonus)
+		default:
+			return nil, fmt.Errorf("%w: неизвестный тип провайдера %q", ErrProviderConfig, pc.Type)
+		}
+	}
+
+	for prefix, name := range file.Routes {
+		p, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("%w: маршрут %q ведет в неизвестный провайдер %q", ErrProviderConfig, prefix, name)
+		}
+		set.routes[prefix] = p
+	}
+
+	var ok bool
+	if set.fallback, ok = byName[file.Default]; !ok {
+		return nil, fmt.Errorf("%w: неизвестный провайдер по умолчанию %q", ErrProviderConfig, file.Default)
+	}
+
+	return set, nil
+}
+
+func (s *providers) route(number string) AccrualProvider {
+	best := ""
+	provider := s.fallback
+
+	for prefix, p := range s.routes {
+		if strings.HasPrefix(number, prefix) && len(prefix) > len(best) {
+			best, provider = prefix, p
+		}
+	}
+
+	return provider
+}
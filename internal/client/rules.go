@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+
+	"gophermart/internal/storage"
+)
+
+// RulesProvider начисляет процент от суммы заказа и фиксированный бонус
+// сразу, без обращения к внешней системе.
+type RulesProvider struct {
+	name    string
+	percent storage.Points
+	bonus   storage.Points
+}
+
+func NewRulesProvider(name string, percent, bonus storage.Points) *RulesProvider {
+	return &RulesProvider{
+		name:    name,
+		percent: percent,
+		bonus:   bonus,
+	}
+}
+
+func (p *RulesProvider) Name() string {
+	return p.name
+}
+
+func (p *RulesProvider) Accrual(_ context.Context, order storage.Order) (Accrual, error) {
+	return Accrual{
+		Order:   order.Number,
+		Status:  storage.AccrualProcessed,
+		Accrual: order.Amount.Percent(p.percent) + p.bonus,
+	}, nil
+}
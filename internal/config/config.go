@@ -2,19 +2,67 @@ package config
 
 import (
 	"encoding/hex"
+	"errors"
 	"flag"
+	"fmt"
+	"os"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
 
+var (
+	ErrConfig = errors.New("неверная настройка")
+)
+
 type Config struct {
 	Address         string `env:"RUN_ADDRESS"`
 	DB              string `env:"DATABASE_URI"`
 	SecretCookieKey []byte
 	BlackBox        string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+
+	PasswdHash   string `env:"PASSWORD_HASH"`
+	BcryptCost   uint   `env:"BCRYPT_COST"`
+	ArgonTime    uint   `env:"ARGON2_TIME"`
+	ArgonMemory  uint   `env:"ARGON2_MEMORY"`
+	ArgonThreads uint   `env:"ARGON2_THREADS"`
+
+	SessionStore string        `env:"SESSION_STORE"`
+	SessionTTL   time.Duration `env:"SESSION_TTL"`
+
+	CookieKeys     string `env:"COOKIE_KEYS"`
+	CookieKeysFile string `env:"COOKIE_KEYS_FILE"`
+
+	JWTSecret       string        `env:"JWT_SECRET"`
+	AccessTokenTTL  time.Duration `env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL"`
+
+	AccrualWorkers int           `env:"ACCRUAL_WORKERS"`
+	PollInterval   time.Duration `env:"ACCRUAL_POLL_INTERVAL"`
+
+	AccrualRateLimit int           `env:"ACCRUAL_RATE_LIMIT"`
+	AccrualLease     time.Duration `env:"ACCRUAL_LEASE"`
+
+	AccrualMaxAttempts int           `env:"ACCRUAL_MAX_ATTEMPTS"`
+	AccrualBackoff     time.Duration `env:"ACCRUAL_BACKOFF"`
+	AccrualBackoffMax  time.Duration `env:"ACCRUAL_BACKOFF_MAX"`
+
+	AccrualConnectTimeout time.Duration `env:"ACCRUAL_CONNECT_TIMEOUT"`
+	AccrualTimeout        time.Duration `env:"ACCRUAL_TIMEOUT"`
+	BreakerThreshold      int           `env:"ACCRUAL_BREAKER_THRESHOLD"`
+	BreakerCooldown       time.Duration `env:"ACCRUAL_BREAKER_COOLDOWN"`
+
+	CallbackSecret string `env:"ACCRUAL_CALLBACK_SECRET"`
+
+	AccrualProvidersFile string `env:"ACCRUAL_PROVIDERS_FILE"`
+
+	AccrualEager    bool          `env:"ACCRUAL_EAGER"`
+	AccrualDebounce time.Duration `env:"ACCRUAL_DEBOUNCE"`
+
+	IdempotencyTTL time.Duration `env:"IDEMPOTENCY_TTL"`
 }
 
-func NewConfig() *Config {
+func NewConfig() (*Config, error) {
 	var cfg Config
 	var secret string
 
@@ -24,21 +72,158 @@ func NewConfig() *Config {
 	)
 	flag.StringVar(&cfg.DB,
 		"d", "postgresql://localhost:5432/gofermart",
-		"Адрес базы данных с которой работает сервер",
+		"Адрес базы данных с которой работает сервер (memory:// - хранение в памяти)",
 	)
 	flag.StringVar(&cfg.BlackBox,
-		"r", "/api/orders/",
+		"r", "http://127.0.0.1:8081",
 		"Адрес черного ящика, с которой работает сервер",
 	)
 	flag.StringVar(&secret,
 		"k", "BGCbNg8sreipgLH2",
 		"Ключ для шифрования куки",
 	)
+	flag.StringVar(&cfg.PasswdHash,
+		"hash", "argon2id",
+		"Алгоритм хеширования паролей: argon2id или bcrypt",
+	)
+	flag.UintVar(&cfg.BcryptCost,
+		"bcrypt-cost", 12,
+		"Стоимость bcrypt",
+	)
+	flag.UintVar(&cfg.ArgonTime,
+		"argon-time", 1,
+		"Число проходов argon2id",
+	)
+	flag.UintVar(&cfg.ArgonMemory,
+		"argon-memory", 64*1024,
+		"Объем памяти argon2id в КиБ",
+	)
+	flag.UintVar(&cfg.ArgonThreads,
+		"argon-threads", 2,
+		"Число потоков argon2id",
+	)
+	flag.StringVar(&cfg.SessionStore,
+		"sessions", "db",
+		"Хранилище сессий: db или memory",
+	)
+	flag.DurationVar(&cfg.SessionTTL,
+		"session-ttl", 24*time.Hour,
+		"Время жизни сессии без активности",
+	)
+	flag.StringVar(&cfg.CookieKeysFile,
+		"kf", "",
+		"Файл с ключами шифрования куки в формате id:ключ, первый ключ - основной",
+	)
+	flag.StringVar(&cfg.JWTSecret,
+		"j", "",
+		"Ключ подписи токенов доступа, пустой - случайный ключ на время работы процесса",
+	)
+	flag.DurationVar(&cfg.AccessTokenTTL,
+		"access-ttl", 15*time.Minute,
+		"Время жизни токена доступа",
+	)
+	flag.DurationVar(&cfg.RefreshTokenTTL,
+		"refresh-ttl", 30*24*time.Hour,
+		"Время жизни токена обновления",
+	)
+	flag.IntVar(&cfg.AccrualWorkers,
+		"w", 4,
+		"Число воркеров, опрашивающих черный ящик",
+	)
+	flag.DurationVar(&cfg.PollInterval,
+		"poll", time.Second,
+		"Интервал опроса черного ящика",
+	)
+	flag.IntVar(&cfg.AccrualRateLimit,
+		"rate", 0,
+		"Начальный лимит запросов к черному ящику в минуту, 0 - без ограничения",
+	)
+	flag.DurationVar(&cfg.AccrualLease,
+		"lease", 30*time.Second,
+		"Время аренды заказа воркером, после которого заказ возвращается в очередь",
+	)
+	flag.IntVar(&cfg.AccrualMaxAttempts,
+		"max-attempts", 20,
+		"Число неудачных опросов заказа, после которого он помечается застрявшим, 0 - без ограничения",
+	)
+	flag.DurationVar(&cfg.AccrualBackoff,
+		"backoff", time.Second,
+		"Начальная задержка повторного опроса заказа после ошибки",
+	)
+	flag.DurationVar(&cfg.AccrualBackoffMax,
+		"backoff-max", 10*time.Minute,
+		"Максимальная задержка повторного опроса заказа после ошибки",
+	)
+	flag.DurationVar(&cfg.AccrualConnectTimeout,
+		"connect-timeout", 2*time.Second,
+		"Таймаут подключения к черному ящику",
+	)
+	flag.DurationVar(&cfg.AccrualTimeout,
+		"accrual-timeout", 5*time.Second,
+		"Таймаут запроса к черному ящику",
+	)
+	flag.IntVar(&cfg.BreakerThreshold,
+		"breaker-threshold", 5,
+		"Число ошибок черного ящика подряд, после которого запросы к нему приостанавливаются",
+	)
+	flag.DurationVar(&cfg.BreakerCooldown,
+		"breaker-cooldown", 30*time.Second,
+		"Пауза перед пробным запросом к недоступному черному ящику",
+	)
+	flag.StringVar(&cfg.CallbackSecret,
+		"callback-secret", "",
+		"Ключ подписи обратных вызовов черного ящика, пустой - обратные вызовы отключены",
+	)
+	flag.StringVar(&cfg.AccrualProvidersFile,
+		"providers", "",
+		"Файл с системами расчета и маршрутизацией заказов по префиксу номера, пустой - только черный ящик -r",
+	)
+	flag.BoolVar(&cfg.AccrualEager,
+		"eager", false,
+		"Опрашивать черный ящик сразу после загрузки заказа, не дожидаясь следующего цикла",
+	)
+	flag.DurationVar(&cfg.AccrualDebounce,
+		"debounce", 200*time.Millisecond,
+		"Задержка, за которую загрузки заказов собираются в один внеочередной опрос",
+	)
+	flag.DurationVar(&cfg.IdempotencyTTL,
+		"idempotency-ttl", 24*time.Hour,
+		"Время хранения ответа по ключу Idempotency-Key",
+	)
 	flag.Parse()
 
-	_ = env.Parse(&cfg)
+	if err := env.Parse(&cfg); err != nil {
+		return nil, err
+	}
 
-	cfg.SecretCookieKey = []byte(hex.EncodeToString([]byte(secret)))
+	// env.Parse пропускает пустые значения, а пустой DATABASE_URI означает хранение в памяти
+	if uri, ok := os.LookupEnv("DATABASE_URI"); ok && uri == "" {
+		cfg.DB = ""
+	}
+
+	if cfg.AccrualWorkers < 1 {
+		cfg.AccrualWorkers = 1
+	}
+
+	// нулевые интервалы опроса ломают тикер и таймеры клиента системы расчета
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"-poll (ACCRUAL_POLL_INTERVAL)", cfg.PollInterval},
+		{"-lease (ACCRUAL_LEASE)", cfg.AccrualLease},
+		{"-debounce (ACCRUAL_DEBOUNCE)", cfg.AccrualDebounce},
+	} {
+		if d.value <= 0 {
+			return nil, fmt.Errorf("%w: %s должен быть больше нуля", ErrConfig, d.name)
+		}
+	}
+
+	cfg.SecretCookieKey = CookieKey(secret)
+
+	return &cfg, nil
+}
 
-	return &cfg
+func CookieKey(secret string) []byte {
+	return []byte(hex.EncodeToString([]byte(secret)))
 }
@@ -8,25 +8,35 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/gob"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"gophermart/internal/database"
+	"gophermart/internal/session"
 	"gophermart/internal/storage"
 )
 
+const keyIDSeparator = "."
+
 type CookieManager struct {
-	Key []byte
-	db  *database.UserDB
+	Keys     *KeyRing
+	db       database.Repository
+	sessions session.Store
+	ttl      time.Duration
 }
 
-func NewCookieManager(key []byte, db *database.UserDB) *CookieManager {
+func NewCookieManager(keys *KeyRing, db database.Repository, sessions session.Store, ttl time.Duration) *CookieManager {
 	return &CookieManager{
-		Key: key,
-		db:  db,
+		Keys:     keys,
+		db:       db,
+		sessions: sessions,
+		ttl:      ttl,
 	}
 }
 
@@ -36,6 +46,8 @@ var (
 	ErrCipher       = errors.New("ошибка шифрования куки")
 	ErrNoCookie     = errors.New("нет куки")
 	ErrGobZip       = errors.New("ошибка упаковки в gob")
+	ErrSession      = errors.New("ошибка создания сессии")
+	ErrSessionEnded = errors.New("сессия истекла или завершена")
 )
 
 func (c *CookieManager) Write(cookie http.Cookie) (*http.Cookie, error) {
@@ -61,7 +73,9 @@ func (c *CookieManager) Read(cookie *http.Cookie) (string, error) {
 
 func (c *CookieManager) WriteEncrypt(cookie http.Cookie) (*http.Cookie, error) {
 
-	block, err := aes.NewCipher(c.Key)
+	key := c.Keys.Primary()
+
+	block, err := aes.NewCipher(key.Secret)
 	if err != nil {
 		return nil, err
 	}
@@ -79,20 +93,42 @@ func (c *CookieManager) WriteEncrypt(cookie http.Cookie) (*http.Cookie, error) {
 
 	plaintext := fmt.Sprintf("%s:%s", cookie.Name, cookie.Value)
 
-	encryptedValue := aesGCM.Seal(nonce, nonce, []byte(plaintext), nil)
+	encryptedValue := aesGCM.Seal(nonce, nonce, []byte(plaintext), []byte(key.ID))
 
-	cookie.Value = string(encryptedValue)
+	cookie.Value = key.ID + keyIDSeparator + string(encryptedValue)
 
 	return c.Write(cookie)
 }
 
-func (c *CookieManager) ReadEncrypt(cookie *http.Cookie, name string, secretKey []byte) (string, error) {
+func (c *CookieManager) ReadEncrypt(cookie *http.Cookie, name string) (string, error) {
 	encryptedValue, err := c.Read(cookie)
 
 	if err != nil {
 		return "", err
 	}
 
+	if id, sealed, ok := strings.Cut(encryptedValue, keyIDSeparator); ok {
+		if key, found := c.Keys.Get(id); found {
+			value, err := decrypt(key.Secret, sealed, name, []byte(key.ID))
+			if err == nil {
+				return value, nil
+			}
+		}
+	}
+
+	// куки, выпущенные до появления id ключа, проверяем всеми ключами по очереди
+	for _, key := range c.Keys.All() {
+		value, err := decrypt(key.Secret, encryptedValue, name, nil)
+		if err == nil {
+			return value, nil
+		}
+	}
+
+	return "", ErrInvalidValue
+}
+
+func decrypt(secretKey []byte, encryptedValue, name string, additional []byte) (string, error) {
+
 	block, err := aes.NewCipher(secretKey)
 	if err != nil {
 		return "", err
@@ -112,7 +148,7 @@ func (c *CookieManager) ReadEncrypt(cookie *http.Cookie, name string, secretKey
 	nonce := encryptedValue[:nonceSize]
 	ciphertext := encryptedValue[nonceSize:]
 
-	plaintext, err := aesGCM.Open(nil, []byte(nonce), []byte(ciphertext), nil)
+	plaintext, err := aesGCM.Open(nil, []byte(nonce), []byte(ciphertext), additional)
 	if err != nil {
 		return "", ErrInvalidValue
 	}
@@ -128,15 +164,36 @@ func (c *CookieManager) ReadEncrypt(cookie *http.Cookie, name string, secretKey
 
 	return value, nil
 }
+
 func (c *CookieManager) GetCookie(user *storage.User) (final *http.Cookie, err error) {
 	var buffer bytes.Buffer
 
+	ctx := context.Background()
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, ErrSession
+	}
+
+	now := time.Now()
+	err = c.sessions.CreateSession(ctx, &storage.Session{
+		ID:        id,
+		User:      user.Login,
+		CreatedAt: now,
+		ExpiresAt: now.Add(c.ttl),
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	cookie := http.Cookie{
 		Name:  fmt.Sprintf("CookieUser%s", user.Login),
-		Value: user.Login,
+		Value: id,
 
+		// без MaxAge: срок решает сессия на сервере, которая продлевается при активности
 		Secure:   false,
-		HttpOnly: false,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	}
 
 	err = gob.NewEncoder(&buffer).Encode(cookie)
@@ -157,25 +214,42 @@ func (c *CookieManager) CheckCookie(user *storage.User, cookieAll []*http.Cookie
 
 	ctx := context.Background()
 
-	if len(cookieAll) > 0 {
-		for _, cookie := range cookieAll {
-			if cookie != nil {
-				value, err := c.ReadEncrypt(cookie, cookie.Name, c.Key)
-
-				switch err {
-				case ErrCipher:
-					err = ErrCipher
-					return "", err
-				case ErrInvalidValue:
-					err = ErrInvalidValue
-					return "", err
-				case nil:
-					return value, nil
-				}
+	// в запросе могут остаться куки завершенных сессий и чужие куки:
+	// ошибка возвращается, только если не подошла ни одна
+	var failed error
+
+	for _, cookie := range cookieAll {
+		if cookie == nil {
+			continue
+		}
+
+		value, err := c.ReadEncrypt(cookie, cookie.Name)
+		switch err {
+		case nil:
+		case ErrCipher, ErrInvalidValue:
+			if failed == nil {
+				failed = err
 			}
+			continue
+		default:
+			continue
+		}
+
+		login, err := c.checkSession(ctx, value)
+		switch err {
+		case nil:
+			return login, nil
+		case ErrSessionEnded:
+			failed = err
+		default:
+			return "", err
 		}
 	}
 
+	if failed != nil {
+		return "", failed
+	}
+
 	if user != nil {
 		err := c.db.CheckUserWithContext(ctx, user)
 		switch err {
@@ -190,3 +264,67 @@ func (c *CookieManager) CheckCookie(user *storage.User, cookieAll []*http.Cookie
 
 	return "", ErrNoCookie
 }
+
+func (c *CookieManager) checkSession(ctx context.Context, id string) (string, error) {
+
+	s, err := c.sessions.GetSession(ctx, id)
+	switch {
+	case errors.Is(err, session.ErrNotFound):
+		return "", ErrSessionEnded
+	case err != nil:
+		return "", err
+	}
+
+	// скользящее продление: сессия живет ttl с момента последней активности
+	if time.Until(s.ExpiresAt) < c.ttl/2 {
+		err = c.sessions.TouchSession(ctx, id, time.Now().Add(c.ttl))
+		if err != nil {
+			log.Printf("%s: %s", ErrSession, err)
+		}
+	}
+
+	return s.User, nil
+}
+
+func (c *CookieManager) Logout(cookieAll []*http.Cookie) ([]*http.Cookie, error) {
+
+	var expired []*http.Cookie
+
+	ctx := context.Background()
+
+	for _, cookie := range cookieAll {
+		if cookie == nil {
+			continue
+		}
+
+		id, err := c.ReadEncrypt(cookie, cookie.Name)
+		if err != nil {
+			continue
+		}
+
+		if err = c.sessions.DeleteSession(ctx, id); err != nil {
+			return nil, err
+		}
+
+		expired = append(expired, &http.Cookie{
+			Name:   cookie.Name,
+			Value:  "",
+			MaxAge: -1,
+		})
+	}
+
+	return expired, nil
+}
+
+func (c *CookieManager) LogoutAll(login string) error {
+	return c.sessions.DeleteUserSessions(context.Background(), login)
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
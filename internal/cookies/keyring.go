@@ -0,0 +1,118 @@
+package cookies
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gophermart/internal/config"
+)
+
+const defaultKeyID = "default"
+
+var (
+	ErrKeyRing = errors.New("неверная конфигурация ключей шифрования куки")
+)
+
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// KeyRing хранит ключи шифрования куки: первый ключ основной и используется для
+// шифрования, остальные принимаются при проверке, пока их не уберут из конфигурации.
+type KeyRing struct {
+	keys []Key
+}
+
+func NewKeyRing(cfg *config.Config) (*KeyRing, error) {
+
+	switch {
+	case cfg.CookieKeysFile != "":
+		f, err := os.Open(cfg.CookieKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrKeyRing, err)
+		}
+		defer f.Close()
+
+		return parseKeyRing(f)
+
+	case cfg.CookieKeys != "":
+		return parseKeyRing(strings.NewReader(strings.ReplaceAll(cfg.CookieKeys, ",", "\n")))
+
+	default:
+		return newKeyRing([]Key{{ID: defaultKeyID, Secret: cfg.SecretCookieKey}})
+	}
+}
+
+func parseKeyRing(r io.Reader) (*KeyRing, error) {
+
+	var keys []Key
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		id, secret, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: ожидается id:ключ", ErrKeyRing)
+		}
+
+		keys = append(keys, Key{
+			ID:     strings.TrimSpace(id),
+			Secret: config.CookieKey(strings.TrimSpace(secret)),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyRing, err)
+	}
+
+	return newKeyRing(keys)
+}
+
+func newKeyRing(keys []Key) (*KeyRing, error) {
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%w: нет ни одного ключа", ErrKeyRing)
+	}
+
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if key.ID == "" || strings.Contains(key.ID, keyIDSeparator) || seen[key.ID] {
+			return nil, fmt.Errorf("%w: недопустимый id ключа %q", ErrKeyRing, key.ID)
+		}
+		seen[key.ID] = true
+
+		switch len(key.Secret) {
+		case 16, 24, 32:
+		default:
+			return nil, fmt.Errorf("%w: ключ %q должен быть длиной 8, 12 или 16 символов", ErrKeyRing, key.ID)
+		}
+	}
+
+	return &KeyRing{keys: keys}, nil
+}
+
+func (k *KeyRing) Primary() Key {
+	return k.keys[0]
+}
+
+func (k *KeyRing) Get(id string) (Key, bool) {
+	for _, key := range k.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+
+	return Key{}, false
+}
+
+func (k *KeyRing) All() []Key {
+	return k.keys
+}
@@ -6,12 +6,16 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"gophermart/internal/config"
+	"gophermart/internal/passwd"
 	"gophermart/internal/storage"
 
 	_ "github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -23,71 +27,93 @@ var (
 	ErrRowDoesntExists      = errors.New("записи в бд не сущетвует")
 	ErrConnectToDB          = errors.New("ошибка обращения в бд")
 	ErrRowWasCreatedAnyUser = errors.New("дургой пользователь уже добавил номер этого заказа")
+	ErrPasswdHash           = errors.New("ошибка хеширования пароля")
+
+	// баланс и сумма списаний раскрывают ссылку сторно только на один уровень
+	ErrReverseReversal = errors.New("сторно нельзя сторнировать, нужна корректировка")
+)
+
+const (
+	txRetries        = 5
+	migrationTimeout = 30 * time.Second
+
+	orderColumns = "id, user_login, order_number, status, accrual, uploaded_at, amount"
+	queueColumns = orderColumns + ", attempts, COALESCE(last_error, ''), stalled"
 )
 
 type UserDB struct {
-	db *sql.DB
+	db     *sql.DB
+	hasher *passwd.Hasher
 }
 
 func NewUserDB(cfg *config.Config) (*UserDB, error) {
 
-	ctx := context.Background()
+	ctx, cancel := context.WithTimeout(context.Background(), migrationTimeout)
+	defer cancel()
 
-	db, err := sql.Open("pgx", cfg.DB)
+	hasher, err := passwd.NewHasher(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := Open(cfg)
 	if err != nil {
-		log.Println("Не возожно подключиться к бд: ", err)
 		return nil, err
 	}
 
-	err = createAllTablesWithContext(ctx, db)
+	migrator, err := NewMigrator(db)
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
 
+	_, err = migrator.Up(ctx)
+	if err != nil {
+		log.Println(err)
+		return nil, fmt.Errorf("не удалось применить миграции базы данных: %w", err)
+	}
+
 	return &UserDB{
-		db: db,
+		db:     db,
+		hasher: hasher,
 	}, nil
 }
 
-func createAllTablesWithContext(ctx context.Context, db *sql.DB) error {
-
-	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
-	defer cancel()
+func Open(cfg *config.Config) (*sql.DB, error) {
 
-	queries := []string{
-		"CREATE TABLE IF NOT EXISTS withdrawals (id SERIAL PRIMARY KEY, number BIGINT, sum FLOAT, processed_at VARCHAR(50), user_login VARCHAR(20))",
-		"CREATE TABLE IF NOT EXISTS users (id SERIAL PRIMARY KEY, user_login VARCHAR(100), passwd VARCHAR(100), balance FLOAT, withdraw FLOAT);",
-		"CREATE TABLE IF NOT EXISTS orders (id SERIAL PRIMARY KEY, user_login VARCHAR(100), order_number BIGINT, status VARCHAR(10), accrual FLOAT, uploaded_at VARCHAR(50));",
+	db, err := sql.Open("pgx", cfg.DB)
+	if err != nil {
+		log.Println("Не возожно подключиться к бд: ", err)
+		return nil, err
 	}
 
-	for _, query := range queries {
-		r, err := db.ExecContext(childCtx, query)
-		if err != nil {
-			return fmt.Errorf("не удалось создать необходимые таблицы в базе данных. \nОшибка: %s\nОтвет базы данных: %s", err, r)
-		}
+	return db, nil
+}
+
+func (d *UserDB) Ping(ctx context.Context) error {
+	if err := d.db.PingContext(ctx); err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
 	}
 
 	return nil
 }
 
-func (d *UserDB) GetBall(user string) (float64, float64, error) {
-	var bal, with float64
+func (d *UserDB) GetBall(user string) (storage.Points, storage.Points, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
 
-	query := "SELECT balance, withdraw FROM users WHERE user_login = $1;"
+	return d.getBall(ctx, user, nil)
+}
 
-	r, err := d.db.Query(query, user)
-	if err != nil {
-		return 0, 0, ErrConnectToDB
-	}
+func (d *UserDB) GetBallAt(ctx context.Context, user string, at time.Time) (storage.Points, storage.Points, error) {
+	return d.getBall(ctx, user, at)
+}
 
-	r.Next()
-	err = r.Scan(&bal, &with)
-	if err != nil {
-		return 0, 0, ErrConnectToDB
-	}
+func (d *UserDB) getBall(ctx context.Context, user string, at interface{}) (storage.Points, storage.Points, error) {
+	var bal, with storage.Points
 
-	err = r.Close()
+	err := d.db.QueryRowContext(ctx, balanceQuery, user, at).Scan(&bal, &with)
 	if err != nil {
 		log.Printf("%s: %s", ErrConnectToDB, err)
 		return 0, 0, ErrConnectToDB
@@ -96,65 +122,226 @@ func (d *UserDB) GetBall(user string) (float64, float64, error) {
 	return bal, with, nil
 }
 
-func (d *UserDB) Withdraw(ctx context.Context, withdraw *storage.Withdraw) error {
-	childCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+const balanceQuery = `
+		SELECT COALESCE(SUM(l.amount), 0),
+		       COALESCE(-SUM(l.amount) FILTER (WHERE l.kind = 'withdrawal' OR r.kind = 'withdrawal'), 0)
+		FROM ledger l
+		LEFT JOIN ledger r ON r.id = l.ref_id
+		WHERE l.user_login = $1 AND ($2::timestamptz IS NULL OR l.created_at <= $2)
+`
+
+func (d *UserDB) GetLedger(ctx context.Context, user string) (entries []storage.LedgerEntry, err error) {
+
+	var entry storage.LedgerEntry
+	var number sql.NullString
+	var ref sql.NullInt64
+	var createdAt time.Time
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
-	bal, with, err := d.GetBall(withdraw.User)
+	query := "SELECT id, user_login, kind, amount, order_number, ref_id, created_at FROM ledger WHERE user_login = $1 ORDER BY id"
+
+	rows, err := d.db.QueryContext(childCtx, query, user)
 	if err != nil {
-		return err
+		return entries, ErrConnectToDB
 	}
+	defer rows.Close()
 
-	balance := bal - withdraw.Sum
-	/*
-		balance := bal - withdraw.Sum
-		if balance <= 0 {
-			return ErrNotEnoughMoney
+	for rows.Next() {
+		if err = rows.Scan(&entry.ID, &entry.User, &entry.Kind, &entry.Amount,
+			&number, &ref, &createdAt,
+		); err != nil {
+			return entries, err
 		}
-	*/
-	query := "UPDATE users SET withdraw = $1, balance = $2 WHERE user_login = $3"
 
-	_, err = d.db.ExecContext(childCtx, query,
-		with+withdraw.Sum,
-		balance,
-		withdraw.User,
-	)
-	if err != nil {
-		return err
+		entry.Order = ""
+		if number.Valid {
+			entry.Order = number.String
+		}
+		entry.RefID = int(ref.Int64)
+		entry.CreatedAt = createdAt.Format(time.RFC3339)
+
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return entries, err
 	}
+	return entries, nil
+}
+
+func (d *UserDB) InsertLedgerEntryWithContext(ctx context.Context, entry *storage.LedgerEntry) error {
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
 
-	query = "INSERT INTO withdrawals (number, sum, processed_at, user_login) VALUES($1, $2, $3, $4);"
+	return d.inTx(childCtx, func(tx *sql.Tx) error {
+		return insertLedgerEntry(childCtx, tx, entry)
+	})
+}
 
-	_, err = d.db.ExecContext(childCtx, query,
-		withdraw.NumberOrder,
-		withdraw.Sum,
-		withdraw.ProcessedAt,
-		withdraw.User,
-	)
-	if err != nil {
-		return err
+func (d *UserDB) ReverseLedgerEntryWithContext(ctx context.Context, id int) error {
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	return d.inTx(childCtx, func(tx *sql.Tx) error {
+		var entry storage.LedgerEntry
+		var number sql.NullString
+
+		query := "SELECT user_login, kind, amount, order_number FROM ledger WHERE id = $1"
+
+		err := tx.QueryRowContext(childCtx, query, id).Scan(&entry.User, &entry.Kind, &entry.Amount, &number)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRowDoesntExists
+		case err != nil:
+			return err
+		case entry.Kind == storage.LedgerReversal:
+			return ErrReverseReversal
+		}
+
+		var exists bool
+
+		query = "SELECT EXISTS(SELECT * FROM ledger WHERE ref_id = $1)"
+
+		err = tx.QueryRowContext(childCtx, query, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrRowAlreadyExists
+		}
+
+		if number.Valid {
+			entry.Order = number.String
+		}
+		entry.Kind = storage.LedgerReversal
+		entry.Amount = -entry.Amount
+		entry.RefID = id
+
+		return insertLedgerEntry(childCtx, tx, &entry)
+	})
+}
+
+func insertLedgerEntry(ctx context.Context, tx *sql.Tx, entry *storage.LedgerEntry) error {
+	var number, ref interface{}
+
+	if entry.Order != "" {
+		number = entry.Order
+	}
+	if entry.RefID != 0 {
+		ref = entry.RefID
 	}
 
-	return nil
+	query := "INSERT INTO ledger (user_login, kind, amount, order_number, ref_id) VALUES($1, $2, $3, $4, $5);"
 
+	_, err := tx.ExecContext(ctx, query,
+		entry.User,
+		entry.Kind,
+		entry.Amount,
+		number,
+		ref,
+	)
+	return err
 }
 
-func (d *UserDB) sortDate(login string) error {
-	query := `
-			SELECT user_login, processed_at 
-			FROM withdrawals 
-			WHERE user_login = $1
-			ORDER BY processed_at
-	`
+func (d *UserDB) inTx(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
 
-	_, err := d.db.Exec(query, login)
-	if err != nil {
-		return err
+	for attempt := 0; attempt < txRetries; attempt++ {
+		var tx *sql.Tx
+
+		tx, err = d.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+		if err != nil {
+			return ErrConnectToDB
+		}
+
+		err = fn(tx)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err == nil {
+			return nil
+		}
+
+		_ = tx.Rollback()
+
+		if !isSerializationFailure(err) {
+			return err
+		}
 	}
 
-	return nil
+	log.Printf("%s: %s", ErrConnectToDB, err)
+	return ErrConnectToDB
+}
+
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState() == "40001" || pgErr.SQLState() == "40P01"
+	}
+	return false
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.SQLState() == "23505"
+	}
+	return false
 }
-func (d *UserDB) GetAllWithdraw(ctx context.Context, user *storage.User) (withdrawals []storage.Withdraw, err error) {
+
+func (d *UserDB) Withdraw(ctx context.Context, withdraw *storage.Withdraw) error {
+	childCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	return d.inTx(childCtx, func(tx *sql.Tx) error {
+		var bal, with storage.Points
+		var id int
+
+		query := "SELECT id FROM users WHERE user_login = $1 FOR UPDATE;"
+
+		err := tx.QueryRowContext(childCtx, query, withdraw.User).Scan(&id)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRowDoesntExists
+		case err != nil:
+			return err
+		}
+
+		err = tx.QueryRowContext(childCtx, balanceQuery, withdraw.User, nil).Scan(&bal, &with)
+		if err != nil {
+			return err
+		}
+
+		if bal < withdraw.Sum {
+			return ErrNotEnoughMoney
+		}
+
+		err = insertLedgerEntry(childCtx, tx, &storage.LedgerEntry{
+			User:   withdraw.User,
+			Kind:   storage.LedgerWithdrawal,
+			Amount: -withdraw.Sum,
+			Order:  withdraw.NumberOrder,
+		})
+		if err != nil {
+			return err
+		}
+
+		query = "INSERT INTO withdrawals (number, sum, processed_at, user_login) VALUES($1, $2, $3, $4);"
+
+		_, err = tx.ExecContext(childCtx, query,
+			withdraw.NumberOrder,
+			withdraw.Sum,
+			withdraw.ProcessedAt,
+			withdraw.User,
+		)
+		if isUniqueViolation(err) {
+			return ErrRowAlreadyExists
+		}
+		return err
+	})
+}
+
+func (d *UserDB) GetAllWithdraw(ctx context.Context, user *storage.User, page storage.Page) (withdrawals []storage.Withdraw, next *storage.Cursor, err error) {
 
 	var wtd storage.Withdraw
 	var rows *sql.Rows
@@ -162,59 +349,88 @@ func (d *UserDB) GetAllWithdraw(ctx context.Context, user *storage.User) (withdr
 	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
-	err = d.sortDate(user.Login)
-	if err != nil {
-		return nil, err
-	}
-
-	query := "SELECT * FROM withdrawals WHERE user_login = $1"
+	filter, args := pageQuery("processed_at", page, user.Login)
+	query := "SELECT id, number, sum, processed_at, user_login FROM withdrawals WHERE user_login = $1" + filter
 
-	rows, err = d.db.QueryContext(childCtx, query, user.Login)
+	rows, err = d.db.QueryContext(childCtx, query, args...)
 	if err != nil {
-		return withdrawals, err
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return withdrawals, nil, ErrConnectToDB
 	}
-	defer func() {
-		err = rows.Close()
-		if err != nil {
-			log.Println(err)
-			return
-		}
-	}()
+	defer rows.Close()
 
 	for rows.Next() {
 		if err = rows.Scan(&wtd.ID, &wtd.NumberOrder, &wtd.Sum, &wtd.ProcessedAt, &wtd.User); err != nil {
-			return withdrawals, err
+			return withdrawals, nil, err
 		}
 
 		withdrawals = append(withdrawals, wtd)
 	}
 	if err = rows.Err(); err != nil {
-		return withdrawals, err
+		return withdrawals, nil, err
+	}
+
+	if len(withdrawals) > page.Limit {
+		withdrawals = withdrawals[:page.Limit]
+		last := withdrawals[page.Limit-1]
+		next, err = nextCursor(last.ProcessedAt, last.ID)
 	}
-	return withdrawals, nil
+	return withdrawals, next, err
 }
 
-func (d *UserDB) UserBalanceUpdater(ctx context.Context, order *storage.Order) error {
+// pageQuery дописывает к запросу фильтры, курсор и сортировку страницы. Строк
+// выбирается на одну больше лимита, чтобы понять, есть ли следующая страница.
+func pageQuery(column string, page storage.Page, args ...interface{}) (string, []interface{}) {
 
-	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
-	defer cancel()
+	var b strings.Builder
 
-	bal, _, err := d.GetBall(order.User)
-	if err != nil {
-		return err
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
 	}
 
-	query := "UPDATE users SET balance = $1 WHERE user_login = $2"
+	if page.Status != "" {
+		b.WriteString(" AND status = " + arg(page.Status))
+	}
+	if !page.From.IsZero() {
+		b.WriteString(" AND " + column + " >= " + arg(page.From))
+	}
+	if !page.To.IsZero() {
+		b.WriteString(" AND " + column + " < " + arg(page.To))
+	}
 
-	_, err = d.db.ExecContext(childCtx, query,
-		order.Accrual+bal,
-		order.User,
-	)
+	cmp, dir := ">", "ASC"
+	if page.Desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	if page.After != nil {
+		b.WriteString(" AND (" + column + ", id) " + cmp + " (" + arg(page.After.At) + ", " + arg(page.After.ID) + ")")
+	}
+
+	b.WriteString(" ORDER BY " + column + " " + dir + ", id " + dir)
+	b.WriteString(" LIMIT " + arg(page.Limit+1))
+
+	return b.String(), args
+}
+
+func nextCursor(at string, id int) (*storage.Cursor, error) {
+	t, err := time.Parse(time.RFC3339Nano, at)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return &storage.Cursor{At: t, ID: id}, nil
+}
+
+func (d *UserDB) UserBalanceUpdater(ctx context.Context, order *storage.Order) error {
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	return d.inTx(childCtx, func(tx *sql.Tx) error {
+		return creditOrder(childCtx, tx, order)
+	})
 }
 
 func (d *UserDB) SetStatus(ctx context.Context, order *storage.Order) error {
@@ -222,32 +438,85 @@ func (d *UserDB) SetStatus(ctx context.Context, order *storage.Order) error {
 	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
-	query := "UPDATE orders SET status = $1, accrual = $2 WHERE order_number = $3"
+	return d.inTx(childCtx, func(tx *sql.Tx) error {
+		var current string
 
-	_, err := d.db.ExecContext(childCtx, query,
-		order.Status,
-		order.Accrual,
-		order.Number,
-	)
-	if err != nil {
-		return err
-	}
+		query := "SELECT user_login, status FROM orders WHERE order_number = $1 FOR UPDATE"
+
+		err := tx.QueryRowContext(childCtx, query, order.Number).Scan(&order.User, &current)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRowDoesntExists
+		case err != nil:
+			return err
+		}
+
+		changed, err := storage.CheckTransition(current, order.Status)
+		if err != nil || !changed {
+			return err
+		}
+
+		if order.Status != storage.StatusProcessed {
+			order.Accrual = 0
+		}
+
+		query = "UPDATE orders SET status = $1, accrual = $2 WHERE order_number = $3"
+
+		_, err = tx.ExecContext(childCtx, query,
+			order.Status,
+			order.Accrual,
+			order.Number,
+		)
+		if err != nil {
+			return err
+		}
 
-	if order.Status == "PROCESSED" {
-		bal, _, _ := d.GetBall(order.User)
-		query = "UPDATE users SET balance = $1 WHERE user_login = $2"
+		query = "INSERT INTO order_history (order_number, old_status, new_status, accrual) VALUES($1, $2, $3, $4)"
 
-		_, err = d.db.ExecContext(childCtx, query,
-			order.Accrual+bal,
-			order.User,
+		_, err = tx.ExecContext(childCtx, query,
+			order.Number,
+			current,
+			order.Status,
+			order.Accrual,
 		)
 		if err != nil {
 			return err
 		}
+
+		if order.Status != storage.StatusProcessed {
+			return nil
+		}
+
+		return creditOrder(childCtx, tx, order)
+	})
+}
+
+// creditOrder начисляет баллы за заказ не больше одного раза: повторный вызов
+// упирается в первичный ключ order_credits и ничего не меняет.
+func creditOrder(ctx context.Context, tx *sql.Tx, order *storage.Order) error {
+
+	query := "INSERT INTO order_credits (order_number, user_login) VALUES($1, $2) ON CONFLICT DO NOTHING"
+
+	r, err := tx.ExecContext(ctx, query, order.Number, order.User)
+	if err != nil {
+		return err
 	}
 
-	return nil
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		log.Printf("начисление за заказ %s уже выполнено", order.Number)
+		return nil
+	}
 
+	return insertLedgerEntry(ctx, tx, &storage.LedgerEntry{
+		User:   order.User,
+		Kind:   storage.LedgerAccrual,
+		Amount: order.Accrual,
+		Order:  order.Number,
+	})
 }
 
 func (d *UserDB) GetAllOrders(ctx context.Context) (orders []storage.Order, err error) {
@@ -258,23 +527,17 @@ func (d *UserDB) GetAllOrders(ctx context.Context) (orders []storage.Order, err
 	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
-	query := "SELECT * FROM orders"
+	query := "SELECT " + orderColumns + " FROM orders"
 
 	rows, err = d.db.QueryContext(childCtx, query)
 	if err != nil {
 		return orders, ErrConnectToDB
 	}
-	defer func() {
-		err = rows.Close()
-		if err != nil {
-			log.Println(err)
-			return
-		}
-	}()
+	defer rows.Close()
 
 	for rows.Next() {
 		if err = rows.Scan(&ord.ID, &ord.User, &ord.Number, &ord.Status,
-			&ord.Accrual, &ord.UploadedAt,
+			&ord.Accrual, &ord.UploadedAt, &ord.Amount,
 		); err != nil {
 			return orders, err
 		}
@@ -287,32 +550,60 @@ func (d *UserDB) GetAllOrders(ctx context.Context) (orders []storage.Order, err
 	return orders, nil
 }
 
-func (d *UserDB) GetAllUserOrders(ctx context.Context, login string) (orders []storage.Order, err error) {
+// ClaimOrders берет в аренду заказы, которые пора опросить. SKIP LOCKED позволяет
+// нескольким экземплярам делить очередь, а истекшая аренда возвращает заказ в работу.
+// Заказы из skip еще опрашиваются этим экземпляром и аренду не продлевают.
+func (d *UserDB) ClaimOrders(ctx context.Context, limit int, lease time.Duration, skip []string) ([]storage.Order, error) {
 
-	var ord storage.Order
+	if skip == nil {
+		skip = []string{}
+	}
 
 	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
-	query := "SELECT * FROM orders WHERE user_login = $1"
+	query := `
+			UPDATE orders SET locked_until = now() + $2 * interval '1 millisecond'
+			WHERE id IN (
+				SELECT id FROM orders
+				WHERE status IN ('NEW', 'PROCESSING')
+				  AND NOT stalled
+				  AND next_attempt_at <= now()
+				  AND (locked_until IS NULL OR locked_until < now())
+				  AND NOT (order_number = ANY($3))
+				ORDER BY next_attempt_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING ` + queueColumns
+
+	return d.queryQueue(childCtx, query, limit, lease.Milliseconds(), skip)
+}
+
+func (d *UserDB) GetStalledOrders(ctx context.Context) ([]storage.Order, error) {
 
-	rows, err := d.db.QueryContext(childCtx, query,
-		login,
-	)
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	query := "SELECT " + queueColumns + " FROM orders WHERE stalled ORDER BY id"
+
+	return d.queryQueue(childCtx, query)
+}
+
+func (d *UserDB) queryQueue(ctx context.Context, query string, args ...interface{}) (orders []storage.Order, err error) {
+
+	var ord storage.Order
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return orders, err
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return orders, ErrConnectToDB
 	}
-	defer func() {
-		err = rows.Close()
-		if err != nil {
-			log.Println(err)
-			return
-		}
-	}()
+	defer rows.Close()
 
 	for rows.Next() {
 		if err = rows.Scan(&ord.ID, &ord.User, &ord.Number, &ord.Status,
-			&ord.Accrual, &ord.UploadedAt,
+			&ord.Accrual, &ord.UploadedAt, &ord.Amount, &ord.Attempts, &ord.LastError, &ord.Stalled,
 		); err != nil {
 			return orders, err
 		}
@@ -325,11 +616,155 @@ func (d *UserDB) GetAllUserOrders(ctx context.Context, login string) (orders []s
 	return orders, nil
 }
 
+func (d *UserDB) ReleaseOrder(ctx context.Context, number string, nextAttempt time.Time) error {
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	query := "UPDATE orders SET locked_until = NULL, next_attempt_at = $1 WHERE order_number = $2"
+
+	_, err := d.db.ExecContext(childCtx, query, nextAttempt, number)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	}
+
+	return nil
+}
+
+func (d *UserDB) FailOrder(ctx context.Context, number, reason string, nextAttempt time.Time, stalled bool) error {
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	query := `
+			UPDATE orders
+			SET locked_until = NULL, next_attempt_at = $1, attempts = attempts + 1, last_error = $2, stalled = $3
+			WHERE order_number = $4
+	`
+
+	_, err := d.db.ExecContext(childCtx, query, nextAttempt, reason, stalled, number)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	}
+
+	return nil
+}
+
+func (d *UserDB) RequeueOrder(ctx context.Context, number string) error {
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	query := `
+			UPDATE orders
+			SET locked_until = NULL, next_attempt_at = now(), attempts = 0, last_error = NULL, stalled = false
+			WHERE order_number = $1 AND stalled
+	`
+
+	r, err := d.db.ExecContext(childCtx, query, number)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	}
+
+	if n, _ := r.RowsAffected(); n == 0 {
+		return ErrRowDoesntExists
+	}
+
+	return nil
+}
+
+func (d *UserDB) GetAllUserOrders(ctx context.Context, login string, page storage.Page) (orders []storage.Order, next *storage.Cursor, err error) {
+
+	var ord storage.Order
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	filter, args := pageQuery("uploaded_at", page, login)
+	query := "SELECT " + orderColumns + " FROM orders WHERE user_login = $1" + filter
+
+	rows, err := d.db.QueryContext(childCtx, query, args...)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return orders, nil, ErrConnectToDB
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err = rows.Scan(&ord.ID, &ord.User, &ord.Number, &ord.Status,
+			&ord.Accrual, &ord.UploadedAt, &ord.Amount,
+		); err != nil {
+			return orders, nil, err
+		}
+
+		orders = append(orders, ord)
+	}
+	if err = rows.Err(); err != nil {
+		return orders, nil, err
+	}
+
+	if len(orders) > page.Limit {
+		orders = orders[:page.Limit]
+		last := orders[page.Limit-1]
+		next, err = nextCursor(last.UploadedAt, last.ID)
+	}
+	return orders, next, err
+}
+
+// GetUserOrder не отличает чужой заказ от несуществующего, чтобы не раскрывать чужие номера.
+func (d *UserDB) GetUserOrder(ctx context.Context, login, number string) (detail storage.OrderDetail, err error) {
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	query := "SELECT " + orderColumns + " FROM orders WHERE user_login = $1 AND order_number = $2"
+
+	err = d.db.QueryRowContext(childCtx, query, login, number).Scan(
+		&detail.ID, &detail.User, &detail.Number, &detail.Status,
+		&detail.Accrual, &detail.UploadedAt, &detail.Amount,
+	)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return detail, ErrRowDoesntExists
+	case err != nil:
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return detail, ErrConnectToDB
+	}
+
+	query = "SELECT changed_at, COALESCE(old_status, ''), new_status, accrual FROM order_history WHERE order_number = $1 ORDER BY id"
+
+	rows, err := d.db.QueryContext(childCtx, query, number)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return detail, ErrConnectToDB
+	}
+	defer rows.Close()
+
+	detail.History = []storage.OrderEvent{}
+
+	for rows.Next() {
+		var event storage.OrderEvent
+
+		if err = rows.Scan(&event.At, &event.From, &event.To, &event.Accrual); err != nil {
+			return detail, err
+		}
+
+		detail.History = append(detail.History, event)
+	}
+	if err = rows.Err(); err != nil {
+		return detail, err
+	}
+	return detail, nil
+}
+
 func (d *UserDB) InsertOrderWithContext(ctx context.Context, order *storage.Order) error {
 	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO orders (user_login, order_number, status, accrual, uploaded_at) VALUES($1, $2, $3, $4, $5);"
+	query := "INSERT INTO orders (user_login, order_number, status, accrual, uploaded_at, amount) VALUES($1, $2, $3, $4, $5, $6);"
 
 	_, err := d.db.ExecContext(childCtx, query,
 		order.User,
@@ -337,7 +772,11 @@ func (d *UserDB) InsertOrderWithContext(ctx context.Context, order *storage.Orde
 		order.Status,
 		order.Accrual,
 		order.UploadedAt,
+		order.Amount,
 	)
+	if isUniqueViolation(err) {
+		return d.orderOwner(childCtx, order)
+	}
 	if err != nil {
 		return err
 	}
@@ -346,6 +785,24 @@ func (d *UserDB) InsertOrderWithContext(ctx context.Context, order *storage.Orde
 
 }
 
+// orderOwner объясняет нарушение уникальности номера: CheckOrderWithContext
+// и вставка не атомарны, и номер мог занять параллельный запрос.
+func (d *UserDB) orderOwner(ctx context.Context, order *storage.Order) error {
+
+	var owner string
+
+	err := d.db.QueryRowContext(ctx, "SELECT user_login FROM orders WHERE order_number = $1", order.Number).Scan(&owner)
+	switch {
+	case err != nil:
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	case owner == order.User:
+		return ErrRowAlreadyExists
+	default:
+		return ErrRowWasCreatedAnyUser
+	}
+}
+
 func (d *UserDB) CheckOrderWithContext(ctx context.Context, order *storage.Order) error {
 
 	var result bool
@@ -417,12 +874,18 @@ func (d *UserDB) InsertUserWithContext(ctx context.Context, user *storage.User)
 		return ErrConnectToDB
 	}
 
-	_, err := d.db.ExecContext(childCtx, "INSERT INTO users (user_login, passwd, balance, withdraw) VALUES($1, $2, $3, $4);",
+	hash, err := d.hasher.Hash(user.Passwd)
+	if err != nil {
+		return err
+	}
+
+	_, err = d.db.ExecContext(childCtx, "INSERT INTO users (user_login, passwd) VALUES($1, $2);",
 		user.Login,
-		user.Passwd,
-		user.Balance,
-		user.Withdraw,
+		hash,
 	)
+	if isUniqueViolation(err) {
+		return ErrRowAlreadyExists
+	}
 	if err != nil {
 		return ErrConnectToDB
 	}
@@ -432,38 +895,45 @@ func (d *UserDB) InsertUserWithContext(ctx context.Context, user *storage.User)
 
 func (d *UserDB) CheckUserWithContext(ctx context.Context, user *storage.User) error {
 
-	var result bool
+	var stored string
 
 	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
 
-	query := "SELECT EXISTS(SELECT * FROM users WHERE user_login = $1 AND passwd = $2)"
+	query := "SELECT passwd FROM users WHERE user_login = $1 LIMIT 1"
 
-	r, err := d.db.QueryContext(childCtx, query,
-		user.Login,
-		user.Passwd,
-	)
-	if err != nil {
+	err := d.db.QueryRowContext(childCtx, query, user.Login).Scan(&stored)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		d.hasher.VerifyMissing(user.Passwd)
+		return ErrRowDoesntExists
+	case err != nil:
 		return ErrConnectToDB
 	}
-	defer func() {
-		err = r.Close()
-		if err != nil {
-			log.Printf("%s: %s", ErrConnectToDB, err)
-			return
-		}
-	}()
 
-	r.Next()
-	err = r.Scan(&result)
+	ok, needsRehash, err := d.hasher.Verify(stored, user.Passwd)
 	if err != nil {
-		return ErrConnectToDB
+		log.Printf("%s: %s", ErrPasswdHash, err)
+		return ErrRowDoesntExists
 	}
-
-	if result {
-		return nil
+	if !ok {
+		return ErrRowDoesntExists
 	}
 
-	return ErrRowDoesntExists
+	if needsRehash {
+		hash, err := d.hasher.Hash(user.Passwd)
+		if err != nil {
+			log.Printf("%s: %s", ErrPasswdHash, err)
+			return nil
+		}
+
+		query = "UPDATE users SET passwd = $1 WHERE user_login = $2 AND passwd = $3"
 
+		_, err = d.db.ExecContext(childCtx, query, hash, user.Login, stored)
+		if err != nil {
+			log.Printf("%s: %s", ErrConnectToDB, err)
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+)
+
+// openTestDB подключается к Postgres из DATABASE_URI и применяет миграции.
+// Без DATABASE_URI тесты на настоящей базе пропускаются.
+func openTestDB(t *testing.T) *UserDB {
+	t.Helper()
+
+	uri := os.Getenv("DATABASE_URI")
+	if uri == "" || IsMemory(&config.Config{DB: uri}) {
+		t.Skip("DATABASE_URI не задан")
+	}
+
+	d, err := NewUserDB(&config.Config{
+		DB:         uri,
+		PasswdHash: "bcrypt",
+		BcryptCost: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = d.db.Close()
+	})
+
+	return d
+}
+
+// testLogin - пользователь, под которым тест пишет в общую базу; его строки удаляются после теста.
+func testLogin(t *testing.T, d *UserDB) string {
+	t.Helper()
+
+	login := fmt.Sprintf("test%d", time.Now().UnixNano())
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		for _, query := range []string{
+			"DELETE FROM order_history WHERE order_number IN (SELECT order_number FROM orders WHERE user_login = $1)",
+			"DELETE FROM order_credits WHERE user_login = $1",
+			"DELETE FROM orders WHERE user_login = $1",
+		} {
+			if _, err := d.db.ExecContext(ctx, query, login); err != nil {
+				t.Errorf("%s: %s", query, err)
+			}
+		}
+	})
+
+	return login
+}
+
+func TestUserDBClaimOrders(t *testing.T) {
+	d := openTestDB(t)
+	login := testLogin(t, d)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	numbers := make([]string, 3)
+	for i := range numbers {
+		numbers[i] = fmt.Sprintf("%s%d", login[4:], i)
+
+		err := d.InsertOrderWithContext(ctx, &storage.Order{
+			User:       login,
+			Number:     numbers[i],
+			Status:     storage.StatusNew,
+			UploadedAt: time.Now().Format(time.RFC3339),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed := func(skip []string) map[string]bool {
+		t.Helper()
+
+		orders, err := d.ClaimOrders(ctx, storage.MaxPageLimit, time.Minute, skip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ours := make(map[string]bool)
+		for _, order := range orders {
+			if order.User == login {
+				ours[order.Number] = true
+			}
+		}
+		return ours
+	}
+
+	// заказ из skip не берется в аренду
+	got := claimed([]string{numbers[1]})
+	if !got[numbers[0]] || got[numbers[1]] || !got[numbers[2]] {
+		t.Fatalf("первая выборка = %v, want %s и %s", got, numbers[0], numbers[2])
+	}
+
+	// арендованные заказы повторно не выдаются, пропущенный свободен
+	got = claimed(nil)
+	if len(got) != 1 || !got[numbers[1]] {
+		t.Fatalf("вторая выборка = %v, want только %s", got, numbers[1])
+	}
+
+	// снятая аренда возвращает заказ в очередь
+	if err := d.ReleaseOrder(ctx, numbers[0], time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	got = claimed(nil)
+	if len(got) != 1 || !got[numbers[0]] {
+		t.Fatalf("выборка после ReleaseOrder = %v, want только %s", got, numbers[0])
+	}
+}
+
+func TestUserDBInsertOrderUnique(t *testing.T) {
+	d := openTestDB(t)
+	login := testLogin(t, d)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	number := login[4:]
+
+	insert := func(user string) error {
+		return d.InsertOrderWithContext(ctx, &storage.Order{
+			User:       user,
+			Number:     number,
+			Status:     storage.StatusNew,
+			UploadedAt: time.Now().Format(time.RFC3339),
+		})
+	}
+
+	if err := insert(login); err != nil {
+		t.Fatal(err)
+	}
+
+	// проверка CheckOrderWithContext пропущена, как при гонке двух загрузок
+	if err := insert(login); err != ErrRowAlreadyExists {
+		t.Errorf("повторная загрузка тем же пользователем = %v, want %v", err, ErrRowAlreadyExists)
+	}
+	if err := insert(login + "x"); err != ErrRowWasCreatedAnyUser {
+		t.Errorf("загрузка другим пользователем = %v, want %v", err, ErrRowWasCreatedAnyUser)
+	}
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"gophermart/internal/storage"
+)
+
+// BeginIdempotency занимает ключ под новый запрос. Истекший ключ и ключ, исходный
+// запрос по которому не завершился за staleAfter, занимаются заново. Если ключ
+// занят, возвращается сохраненная запись.
+func (d *UserDB) BeginIdempotency(ctx context.Context, key *storage.IdempotencyKey, staleAfter time.Duration) (*storage.IdempotencyKey, error) {
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	query := `
+			INSERT INTO idempotency_keys (user_login, key, request_hash, expires_at)
+			VALUES($1, $2, $3, $4)
+			ON CONFLICT (user_login, key) DO UPDATE
+			SET request_hash = EXCLUDED.request_hash, status = 0, content_type = '', body = NULL,
+			    created_at = now(), expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at < now()
+			   OR (idempotency_keys.status = 0 AND idempotency_keys.created_at < now() - $5 * interval '1 millisecond')
+			RETURNING created_at
+	`
+
+	err := d.db.QueryRowContext(childCtx, query,
+		key.User,
+		key.Key,
+		key.RequestHash,
+		key.ExpiresAt,
+		staleAfter.Milliseconds(),
+	).Scan(&key.CreatedAt)
+	switch {
+	case err == nil:
+		return nil, nil
+	case !errors.Is(err, sql.ErrNoRows):
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return nil, ErrConnectToDB
+	}
+
+	var stored storage.IdempotencyKey
+
+	query = `
+			SELECT user_login, key, request_hash, status, content_type, COALESCE(body, ''::bytea), created_at, expires_at
+			FROM idempotency_keys WHERE user_login = $1 AND key = $2
+	`
+
+	err = d.db.QueryRowContext(childCtx, query, key.User, key.Key).Scan(
+		&stored.User,
+		&stored.Key,
+		&stored.RequestHash,
+		&stored.Status,
+		&stored.ContentType,
+		&stored.Body,
+		&stored.CreatedAt,
+		&stored.ExpiresAt,
+	)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return nil, ErrConnectToDB
+	}
+
+	return &stored, nil
+}
+
+func (d *UserDB) CompleteIdempotency(ctx context.Context, key *storage.IdempotencyKey) error {
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(childCtx, "DELETE FROM idempotency_keys WHERE expires_at < now()")
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+	}
+
+	query := "UPDATE idempotency_keys SET status = $1, content_type = $2, body = $3 WHERE user_login = $4 AND key = $5"
+
+	_, err = d.db.ExecContext(childCtx, query,
+		key.Status,
+		key.ContentType,
+		key.Body,
+		key.User,
+		key.Key,
+	)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	}
+
+	return nil
+}
+
+func (d *UserDB) DeleteIdempotency(ctx context.Context, user, key string) error {
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(childCtx, "DELETE FROM idempotency_keys WHERE user_login = $1 AND key = $2", user, key)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	}
+
+	return nil
+}
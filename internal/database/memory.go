@@ -0,0 +1,573 @@
+package database
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/passwd"
+	"gophermart/internal/storage"
+)
+
+type memoryLedgerEntry struct {
+	entry storage.LedgerEntry
+	at    time.Time
+}
+
+type memoryQueueState struct {
+	nextAttempt time.Time
+	lockedUntil time.Time
+	attempts    int
+	lastError   string
+	stalled     bool
+}
+
+type MemoryDB struct {
+	mu     sync.RWMutex
+	hasher *passwd.Hasher
+
+	users       map[string]storage.User
+	orders      []storage.Order
+	withdrawals []storage.Withdraw
+	ledger      []memoryLedgerEntry
+	credited    map[string]bool
+	queue       map[string]memoryQueueState
+	history     map[string][]storage.OrderEvent
+	idempotency map[string]storage.IdempotencyKey
+}
+
+func NewMemoryDB(cfg *config.Config) (*MemoryDB, error) {
+	hasher, err := passwd.NewHasher(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MemoryDB{
+		hasher:      hasher,
+		users:       make(map[string]storage.User),
+		credited:    make(map[string]bool),
+		queue:       make(map[string]memoryQueueState),
+		history:     make(map[string][]storage.OrderEvent),
+		idempotency: make(map[string]storage.IdempotencyKey),
+	}, nil
+}
+
+func (m *MemoryDB) Ping(_ context.Context) error {
+	return nil
+}
+
+func (m *MemoryDB) InsertUserWithContext(_ context.Context, user *storage.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[user.Login]; ok {
+		return ErrRowAlreadyExists
+	}
+
+	hash, err := m.hasher.Hash(user.Passwd)
+	if err != nil {
+		return err
+	}
+
+	m.users[user.Login] = storage.User{
+		Login:  user.Login,
+		Passwd: hash,
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) CheckUserWithContext(_ context.Context, user *storage.User) error {
+	m.mu.RLock()
+	stored, ok := m.users[user.Login]
+	m.mu.RUnlock()
+
+	if !ok {
+		m.hasher.VerifyMissing(user.Passwd)
+		return ErrRowDoesntExists
+	}
+
+	ok, needsRehash, err := m.hasher.Verify(stored.Passwd, user.Passwd)
+	if err != nil {
+		log.Printf("%s: %s", ErrPasswdHash, err)
+		return ErrRowDoesntExists
+	}
+	if !ok {
+		return ErrRowDoesntExists
+	}
+
+	if needsRehash {
+		hash, err := m.hasher.Hash(user.Passwd)
+		if err != nil {
+			log.Printf("%s: %s", ErrPasswdHash, err)
+			return nil
+		}
+
+		m.mu.Lock()
+		if m.users[user.Login].Passwd == stored.Passwd {
+			stored.Passwd = hash
+			m.users[user.Login] = stored
+		}
+		m.mu.Unlock()
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) InsertOrderWithContext(_ context.Context, order *storage.Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, stored := range m.orders {
+		if stored.Number != order.Number {
+			continue
+		}
+		if stored.User == order.User {
+			return ErrRowAlreadyExists
+		}
+		return ErrRowWasCreatedAnyUser
+	}
+
+	stored := *order
+	stored.ID = len(m.orders) + 1
+	m.orders = append(m.orders, stored)
+	m.queue[order.Number] = memoryQueueState{nextAttempt: time.Now()}
+
+	return nil
+}
+
+func (m *MemoryDB) CheckOrderWithContext(_ context.Context, order *storage.Order) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, stored := range m.orders {
+		if stored.Number != order.Number {
+			continue
+		}
+		if stored.User == order.User {
+			return ErrRowAlreadyExists
+		}
+		return ErrRowWasCreatedAnyUser
+	}
+
+	return nil
+}
+
+func (m *MemoryDB) GetAllUserOrders(_ context.Context, login string, page storage.Page) (orders []storage.Order, next *storage.Cursor, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, order := range m.orders {
+		at, _ := time.Parse(time.RFC3339Nano, order.UploadedAt)
+		if order.User != login || (page.Status != "" && order.Status != page.Status) || !page.Contains(at, order.ID) {
+			continue
+		}
+		orders = append(orders, order)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		at1, _ := time.Parse(time.RFC3339Nano, orders[i].UploadedAt)
+		at2, _ := time.Parse(time.RFC3339Nano, orders[j].UploadedAt)
+		return page.Less(at1, orders[i].ID, at2, orders[j].ID)
+	})
+
+	if len(orders) > page.Limit {
+		orders = orders[:page.Limit]
+		last := orders[page.Limit-1]
+		next, err = nextCursor(last.UploadedAt, last.ID)
+	}
+
+	return orders, next, err
+}
+
+func (m *MemoryDB) GetUserOrder(_ context.Context, login, number string) (storage.OrderDetail, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, order := range m.orders {
+		if order.Number != number || order.User != login {
+			continue
+		}
+
+		return storage.OrderDetail{
+			Order:   order,
+			History: append([]storage.OrderEvent{}, m.history[number]...),
+		}, nil
+	}
+
+	return storage.OrderDetail{}, ErrRowDoesntExists
+}
+
+func (m *MemoryDB) GetAllOrders(_ context.Context) ([]storage.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]storage.Order(nil), m.orders...), nil
+}
+
+func (m *MemoryDB) ClaimOrders(_ context.Context, limit int, lease time.Duration, skip []string) (orders []storage.Order, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	busy := make(map[string]bool, len(skip))
+	for _, number := range skip {
+		busy[number] = true
+	}
+
+	for _, order := range m.orders {
+		if len(orders) >= limit {
+			break
+		}
+		if storage.IsTerminal(order.Status) || busy[order.Number] {
+			continue
+		}
+
+		state := m.queue[order.Number]
+		if state.stalled || state.nextAttempt.After(now) || state.lockedUntil.After(now) {
+			continue
+		}
+
+		state.lockedUntil = now.Add(lease)
+		m.queue[order.Number] = state
+
+		order.Attempts = state.attempts
+		order.LastError = state.lastError
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+func (m *MemoryDB) ReleaseOrder(_ context.Context, number string, nextAttempt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.queue[number]
+	state.lockedUntil = time.Time{}
+	state.nextAttempt = nextAttempt
+	m.queue[number] = state
+
+	return nil
+}
+
+func (m *MemoryDB) FailOrder(_ context.Context, number, reason string, nextAttempt time.Time, stalled bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.queue[number]
+	state.lockedUntil = time.Time{}
+	state.nextAttempt = nextAttempt
+	state.attempts++
+	state.lastError = reason
+	state.stalled = stalled
+	m.queue[number] = state
+
+	return nil
+}
+
+func (m *MemoryDB) GetStalledOrders(_ context.Context) (orders []storage.Order, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, order := range m.orders {
+		state := m.queue[order.Number]
+		if !state.stalled {
+			continue
+		}
+
+		order.Attempts = state.attempts
+		order.LastError = state.lastError
+		order.Stalled = true
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+func (m *MemoryDB) RequeueOrder(_ context.Context, number string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.queue[number].stalled {
+		return ErrRowDoesntExists
+	}
+
+	m.queue[number] = memoryQueueState{nextAttempt: time.Now()}
+	return nil
+}
+
+func (m *MemoryDB) SetStatus(_ context.Context, order *storage.Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.orders {
+		if m.orders[i].Number != order.Number {
+			continue
+		}
+
+		changed, err := storage.CheckTransition(m.orders[i].Status, order.Status)
+		if err != nil || !changed {
+			return err
+		}
+
+		if order.Status != storage.StatusProcessed {
+			order.Accrual = 0
+		}
+
+		m.history[order.Number] = append(m.history[order.Number], storage.OrderEvent{
+			At:      time.Now().Format(time.RFC3339Nano),
+			From:    m.orders[i].Status,
+			To:      order.Status,
+			Accrual: order.Accrual,
+		})
+
+		order.User = m.orders[i].User
+		m.orders[i].Status = order.Status
+		m.orders[i].Accrual = order.Accrual
+
+		if order.Status == storage.StatusProcessed {
+			m.creditOrder(order)
+		}
+
+		return nil
+	}
+
+	return ErrRowDoesntExists
+}
+
+func (m *MemoryDB) UserBalanceUpdater(_ context.Context, order *storage.Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.creditOrder(order)
+	return nil
+}
+
+func (m *MemoryDB) Withdraw(_ context.Context, withdraw *storage.Withdraw) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[withdraw.User]; !ok {
+		return ErrRowDoesntExists
+	}
+
+	for _, stored := range m.withdrawals {
+		if stored.User == withdraw.User && stored.NumberOrder == withdraw.NumberOrder {
+			return ErrRowAlreadyExists
+		}
+	}
+
+	bal, _ := m.balance(withdraw.User, time.Now())
+	if bal < withdraw.Sum {
+		return ErrNotEnoughMoney
+	}
+
+	m.appendLedger(storage.LedgerEntry{
+		User:   withdraw.User,
+		Kind:   storage.LedgerWithdrawal,
+		Amount: -withdraw.Sum,
+		Order:  withdraw.NumberOrder,
+	})
+
+	stored := *withdraw
+	stored.ID = len(m.withdrawals) + 1
+	m.withdrawals = append(m.withdrawals, stored)
+
+	return nil
+}
+
+func (m *MemoryDB) GetAllWithdraw(_ context.Context, user *storage.User, page storage.Page) (withdrawals []storage.Withdraw, next *storage.Cursor, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, withdraw := range m.withdrawals {
+		at, _ := time.Parse(time.RFC3339Nano, withdraw.ProcessedAt)
+		if withdraw.User != user.Login || !page.Contains(at, withdraw.ID) {
+			continue
+		}
+		withdrawals = append(withdrawals, withdraw)
+	}
+
+	sort.Slice(withdrawals, func(i, j int) bool {
+		at1, _ := time.Parse(time.RFC3339Nano, withdrawals[i].ProcessedAt)
+		at2, _ := time.Parse(time.RFC3339Nano, withdrawals[j].ProcessedAt)
+		return page.Less(at1, withdrawals[i].ID, at2, withdrawals[j].ID)
+	})
+
+	if len(withdrawals) > page.Limit {
+		withdrawals = withdrawals[:page.Limit]
+		last := withdrawals[page.Limit-1]
+		next, err = nextCursor(last.ProcessedAt, last.ID)
+	}
+
+	return withdrawals, next, err
+}
+
+func (m *MemoryDB) BeginIdempotency(_ context.Context, key *storage.IdempotencyKey, staleAfter time.Duration) (*storage.IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	id := key.User + "\x00" + key.Key
+
+	if stored, ok := m.idempotency[id]; ok && stored.ExpiresAt.After(now) &&
+		(stored.Status != 0 || stored.CreatedAt.Add(staleAfter).After(now)) {
+		return &stored, nil
+	}
+
+	for k, stored := range m.idempotency {
+		if !stored.ExpiresAt.After(now) {
+			delete(m.idempotency, k)
+		}
+	}
+
+	key.CreatedAt = now
+	m.idempotency[id] = storage.IdempotencyKey{
+		User:        key.User,
+		Key:         key.Key,
+		RequestHash: key.RequestHash,
+		CreatedAt:   key.CreatedAt,
+		ExpiresAt:   key.ExpiresAt,
+	}
+
+	return nil, nil
+}
+
+func (m *MemoryDB) CompleteIdempotency(_ context.Context, key *storage.IdempotencyKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := key.User + "\x00" + key.Key
+
+	stored, ok := m.idempotency[id]
+	if !ok {
+		return nil
+	}
+
+	stored.Status = key.Status
+	stored.ContentType = key.ContentType
+	stored.Body = append([]byte(nil), key.Body...)
+	m.idempotency[id] = stored
+
+	return nil
+}
+
+func (m *MemoryDB) DeleteIdempotency(_ context.Context, user, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.idempotency, user+"\x00"+key)
+	return nil
+}
+
+func (m *MemoryDB) GetBall(user string) (storage.Points, storage.Points, error) {
+	return m.GetBallAt(context.Background(), user, time.Now())
+}
+
+func (m *MemoryDB) GetBallAt(_ context.Context, user string, at time.Time) (storage.Points, storage.Points, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bal, with := m.balance(user, at)
+	return bal, with, nil
+}
+
+func (m *MemoryDB) GetLedger(_ context.Context, user string) (entries []storage.LedgerEntry, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, e := range m.ledger {
+		if e.entry.User == user {
+			entries = append(entries, e.entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (m *MemoryDB) InsertLedgerEntryWithContext(_ context.Context, entry *storage.LedgerEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.appendLedger(*entry)
+	return nil
+}
+
+func (m *MemoryDB) ReverseLedgerEntryWithContext(_ context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > len(m.ledger) {
+		return ErrRowDoesntExists
+	}
+
+	if m.ledger[id-1].entry.Kind == storage.LedgerReversal {
+		return ErrReverseReversal
+	}
+
+	for _, e := range m.ledger {
+		if e.entry.RefID == id {
+			return ErrRowAlreadyExists
+		}
+	}
+
+	original := m.ledger[id-1].entry
+	m.appendLedger(storage.LedgerEntry{
+		User:   original.User,
+		Kind:   storage.LedgerReversal,
+		Amount: -original.Amount,
+		Order:  original.Order,
+		RefID:  id,
+	})
+
+	return nil
+}
+
+// creditOrder, appendLedger и balance вызываются под m.mu
+func (m *MemoryDB) creditOrder(order *storage.Order) {
+	if m.credited[order.Number] {
+		return
+	}
+
+	m.credited[order.Number] = true
+	m.appendLedger(storage.LedgerEntry{
+		User:   order.User,
+		Kind:   storage.LedgerAccrual,
+		Amount: order.Accrual,
+		Order:  order.Number,
+	})
+}
+
+func (m *MemoryDB) appendLedger(entry storage.LedgerEntry) {
+	now := time.Now()
+
+	entry.ID = len(m.ledger) + 1
+	entry.CreatedAt = now.Format(time.RFC3339)
+
+	m.ledger = append(m.ledger, memoryLedgerEntry{entry: entry, at: now})
+}
+
+func (m *MemoryDB) balance(user string, at time.Time) (bal, with storage.Points) {
+	for _, e := range m.ledger {
+		if e.entry.User != user || e.at.After(at) {
+			continue
+		}
+
+		bal += e.entry.Amount
+
+		kind := e.entry.Kind
+		if e.entry.RefID != 0 {
+			kind = m.ledger[e.entry.RefID-1].entry.Kind
+		}
+		if kind == storage.LedgerWithdrawal {
+			with -= e.entry.Amount
+		}
+	}
+
+	return bal, with
+}
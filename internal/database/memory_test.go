@@ -0,0 +1,340 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+)
+
+func newTestMemoryDB(t *testing.T) *MemoryDB {
+	t.Helper()
+
+	m, err := NewMemoryDB(&config.Config{PasswdHash: "bcrypt", BcryptCost: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestMemoryDBLedgerReversal(t *testing.T) {
+	m := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	const login = "user1"
+
+	if err := m.InsertUserWithContext(ctx, &storage.User{Login: login, Passwd: "Secret123"}); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+
+	steps := []error{
+		m.InsertLedgerEntryWithContext(ctx, &storage.LedgerEntry{User: login, Kind: storage.LedgerAdjustment, Amount: storage.NewPoints(100, 0)}),
+		m.Withdraw(ctx, &storage.Withdraw{User: login, NumberOrder: "2377225624", Sum: storage.NewPoints(30, 0)}),
+	}
+	for i, err := range steps {
+		if err != nil {
+			t.Fatalf("шаг %d: %v", i, err)
+		}
+	}
+
+	check := func(name string, at time.Time, balance, withdrawn storage.Points) {
+		t.Helper()
+
+		b, w, err := m.GetBallAt(ctx, login, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b != balance || w != withdrawn {
+			t.Errorf("%s: баланс %s, списано %s, want %s, %s", name, b, w, balance, withdrawn)
+		}
+	}
+
+	check("после списания", time.Now(), storage.NewPoints(70, 0), storage.NewPoints(30, 0))
+	check("до проводок", before.Add(-time.Second), 0, 0)
+
+	// проводка 2 - списание
+	if err := m.ReverseLedgerEntryWithContext(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	check("после сторно списания", time.Now(), storage.NewPoints(100, 0), 0)
+
+	tests := []struct {
+		id   int
+		want error
+	}{
+		{2, ErrRowAlreadyExists},
+		{3, ErrReverseReversal},
+		{42, ErrRowDoesntExists},
+	}
+	for _, tt := range tests {
+		if err := m.ReverseLedgerEntryWithContext(ctx, tt.id); err != tt.want {
+			t.Errorf("сторно проводки %d = %v, want %v", tt.id, err, tt.want)
+		}
+	}
+
+	check("после отклоненных сторно", time.Now(), storage.NewPoints(100, 0), 0)
+}
+
+func TestMemoryDBUsers(t *testing.T) {
+	m := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	if err := m.InsertUserWithContext(ctx, &storage.User{Login: "user1", Passwd: "Secret123"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InsertUserWithContext(ctx, &storage.User{Login: "user1", Passwd: "Other123"}); err != ErrRowAlreadyExists {
+		t.Errorf("повторная регистрация = %v, want %v", err, ErrRowAlreadyExists)
+	}
+
+	tests := []struct {
+		name string
+		user storage.User
+		want error
+	}{
+		{"верный пароль", storage.User{Login: "user1", Passwd: "Secret123"}, nil},
+		{"неверный пароль", storage.User{Login: "user1", Passwd: "Other123"}, ErrRowDoesntExists},
+		{"неизвестный логин", storage.User{Login: "user2", Passwd: "Secret123"}, ErrRowDoesntExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.CheckUserWithContext(ctx, &tt.user); err != tt.want {
+				t.Errorf("CheckUserWithContext() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryDBOrders(t *testing.T) {
+	m := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	numbers := []string{"12345678903", "2377225624", "79927398713"}
+
+	for i, number := range numbers {
+		order := &storage.Order{
+			User:       "user1",
+			Number:     number,
+			Status:     storage.StatusNew,
+			UploadedAt: start.Add(time.Duration(i) * time.Minute).Format(time.RFC3339Nano),
+		}
+		if err := m.InsertOrderWithContext(ctx, order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dups := []struct {
+		user string
+		want error
+	}{
+		{"user1", ErrRowAlreadyExists},
+		{"user2", ErrRowWasCreatedAnyUser},
+	}
+	for _, tt := range dups {
+		order := &storage.Order{User: tt.user, Number: numbers[0], Status: storage.StatusNew}
+		if err := m.InsertOrderWithContext(ctx, order); err != tt.want {
+			t.Errorf("повторный заказ от %s = %v, want %v", tt.user, err, tt.want)
+		}
+		if err := m.CheckOrderWithContext(ctx, order); err != tt.want {
+			t.Errorf("проверка заказа от %s = %v, want %v", tt.user, err, tt.want)
+		}
+	}
+
+	if _, err := m.GetUserOrder(ctx, "user2", numbers[0]); err != ErrRowDoesntExists {
+		t.Errorf("чужой заказ = %v, want %v", err, ErrRowDoesntExists)
+	}
+
+	// страницы по два заказа, от новых к старым
+	page := storage.Page{Limit: 2, Desc: true}
+
+	orders, next, err := m.GetAllUserOrders(ctx, "user1", page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 2 || orders[0].Number != numbers[2] || orders[1].Number != numbers[1] || next == nil {
+		t.Fatalf("первая страница %v, курсор %v", orders, next)
+	}
+
+	page.After = next
+	orders, next, err = m.GetAllUserOrders(ctx, "user1", page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].Number != numbers[0] || next != nil {
+		t.Errorf("вторая страница %v, курсор %v", orders, next)
+	}
+
+	orders, _, err = m.GetAllUserOrders(ctx, "user2", storage.Page{Limit: 10})
+	if err != nil || len(orders) != 0 {
+		t.Errorf("заказы user2 = %v, %v, want пусто", orders, err)
+	}
+}
+
+func TestMemoryDBQueue(t *testing.T) {
+	m := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	numbers := []string{"12345678903", "2377225624", "79927398713"}
+	for _, number := range numbers {
+		if err := m.InsertOrderWithContext(ctx, &storage.Order{User: "user1", Number: number, Status: storage.StatusNew}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claim := func(name string, skip []string, want ...string) {
+		t.Helper()
+
+		orders, err := m.ClaimOrders(ctx, 10, time.Minute, skip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, order := range orders {
+			got = append(got, order.Number)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: взяты %v, want %v", name, got, want)
+		}
+	}
+
+	claim("пропуск обрабатываемых", numbers[:1], numbers[1:]...)
+	claim("аренда", nil, numbers[0])
+	claim("все арендованы", nil)
+
+	if err := m.ReleaseOrder(ctx, numbers[1], time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	claim("после освобождения", nil, numbers[1])
+
+	if err := m.ReleaseOrder(ctx, numbers[1], time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.FailOrder(ctx, numbers[2], "нет ответа", time.Now(), true); err != nil {
+		t.Fatal(err)
+	}
+	claim("отложенный и остановленный", nil)
+
+	stalled, err := m.GetStalledOrders(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stalled) != 1 || stalled[0].Number != numbers[2] || stalled[0].Attempts != 1 || stalled[0].LastError != "нет ответа" {
+		t.Errorf("остановленные заказы %+v", stalled)
+	}
+
+	if err := m.RequeueOrder(ctx, numbers[0]); err != ErrRowDoesntExists {
+		t.Errorf("возврат неостановленного = %v, want %v", err, ErrRowDoesntExists)
+	}
+	if err := m.RequeueOrder(ctx, numbers[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	// заказ в конечном статусе больше не берется
+	if err := m.SetStatus(ctx, &storage.Order{Number: numbers[2], Status: storage.StatusInvalid}); err != nil {
+		t.Fatal(err)
+	}
+	claim("после конечного статуса", nil)
+}
+
+func TestMemoryDBWithdraw(t *testing.T) {
+	m := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	if err := m.InsertUserWithContext(ctx, &storage.User{Login: "user1", Passwd: "Secret123"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.InsertLedgerEntryWithContext(ctx, &storage.LedgerEntry{User: "user1", Kind: storage.LedgerAdjustment, Amount: storage.NewPoints(100, 0)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		withdraw storage.Withdraw
+		want     error
+	}{
+		{"неизвестный пользователь", storage.Withdraw{User: "user2", NumberOrder: "2377225624", Sum: storage.NewPoints(1, 0)}, ErrRowDoesntExists},
+		{"не хватает средств", storage.Withdraw{User: "user1", NumberOrder: "2377225624", Sum: storage.NewPoints(100, 1)}, ErrNotEnoughMoney},
+		{"списание", storage.Withdraw{User: "user1", NumberOrder: "2377225624", Sum: storage.NewPoints(60, 0)}, nil},
+		{"повтор заказа", storage.Withdraw{User: "user1", NumberOrder: "2377225624", Sum: storage.NewPoints(1, 0)}, ErrRowAlreadyExists},
+		{"остаток меньше суммы", storage.Withdraw{User: "user1", NumberOrder: "12345678903", Sum: storage.NewPoints(50, 0)}, ErrNotEnoughMoney},
+	}
+	for _, tt := range tests {
+		if err := m.Withdraw(ctx, &tt.withdraw); err != tt.want {
+			t.Errorf("%s: Withdraw() = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	bal, with, err := m.GetBall("user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bal != storage.NewPoints(40, 0) || with != storage.NewPoints(60, 0) {
+		t.Errorf("баланс %s, списано %s, want 40.00, 60.00", bal, with)
+	}
+
+	withdrawals, _, err := m.GetAllWithdraw(ctx, &storage.User{Login: "user1"}, storage.Page{Limit: 10})
+	if err != nil || len(withdrawals) != 1 {
+		t.Errorf("списания = %v, %v, want одно", withdrawals, err)
+	}
+}
+
+func TestMemoryDBIdempotency(t *testing.T) {
+	m := newTestMemoryDB(t)
+	ctx := context.Background()
+
+	key := func(hash string, ttl time.Duration) *storage.IdempotencyKey {
+		return &storage.IdempotencyKey{User: "user1", Key: "k1", RequestHash: hash, ExpiresAt: time.Now().Add(ttl)}
+	}
+
+	if stored, err := m.BeginIdempotency(ctx, key("a", time.Hour), time.Minute); err != nil || stored != nil {
+		t.Fatalf("первый запрос: %v, %v", stored, err)
+	}
+
+	stored, err := m.BeginIdempotency(ctx, key("a", time.Hour), time.Minute)
+	if err != nil || stored == nil || stored.Status != 0 {
+		t.Fatalf("запрос в процессе: %+v, %v", stored, err)
+	}
+
+	// ключ другого пользователя независим
+	other := key("a", time.Hour)
+	other.User = "user2"
+	if stored, err := m.BeginIdempotency(ctx, other, time.Minute); err != nil || stored != nil {
+		t.Errorf("ключ user2: %v, %v", stored, err)
+	}
+
+	// зависший запрос занимается заново
+	if stored, err := m.BeginIdempotency(ctx, key("b", time.Hour), 0); err != nil || stored != nil {
+		t.Fatalf("зависший запрос: %v, %v", stored, err)
+	}
+
+	done := key("b", time.Hour)
+	done.Status = 201
+	done.Body = []byte(`{}`)
+	if err := m.CompleteIdempotency(ctx, done); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err = m.BeginIdempotency(ctx, key("b", time.Hour), 0)
+	if err != nil || stored == nil || stored.Status != 201 || stored.RequestHash != "b" || string(stored.Body) != `{}` {
+		t.Fatalf("завершенный запрос: %+v, %v", stored, err)
+	}
+
+	if err := m.DeleteIdempotency(ctx, "user1", "k1"); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := m.BeginIdempotency(ctx, key("c", -time.Second), time.Minute); err != nil || stored != nil {
+		t.Fatalf("после удаления: %v, %v", stored, err)
+	}
+
+	// истекший ключ занимается заново даже в процессе
+	if stored, err := m.BeginIdempotency(ctx, key("d", time.Hour), time.Minute); err != nil || stored != nil {
+		t.Errorf("истекший ключ: %v, %v", stored, err)
+	}
+}
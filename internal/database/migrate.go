@@ -0,0 +1,279 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// ключ pg_advisory_lock, под которым выполняются миграции
+const migrationLockKey = 7246018
+
+var (
+	ErrMigrationFormat = errors.New("неверный формат файла миграции")
+	ErrNoMigrations    = errors.New("нет примененных миграций")
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, file := range files {
+		base := strings.TrimPrefix(file, "migrations/")
+
+		name, direction, ok := strings.Cut(strings.TrimSuffix(base, ".sql"), ".")
+		if !ok || (direction != "up" && direction != "down") {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationFormat, base)
+		}
+
+		number, title, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrMigrationFormat, base)
+		}
+
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: title}
+			byVersion[version] = m
+		}
+
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("%w: у версии %d нет up или down файла", ErrMigrationFormat, m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+func (m *Migrator) Up(ctx context.Context) (applied []Migration, err error) {
+
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := done[migration.Version]; ok {
+				continue
+			}
+
+			query := "INSERT INTO schema_migrations (version, name) VALUES($1, $2);"
+
+			err = runInTx(ctx, conn, migration.Up, query, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("миграция %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("применена миграция %04d_%s", migration.Version, migration.Name)
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+func (m *Migrator) Down(ctx context.Context, steps int) (reverted []Migration, err error) {
+
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := done[migration.Version]; !ok {
+				continue
+			}
+
+			query := "DELETE FROM schema_migrations WHERE version = $1;"
+
+			err = runInTx(ctx, conn, migration.Down, query, migration.Version)
+			if err != nil {
+				return fmt.Errorf("откат миграции %04d_%s: %w", migration.Version, migration.Name, err)
+			}
+
+			log.Printf("откачена миграция %04d_%s", migration.Version, migration.Name)
+			reverted = append(reverted, migration)
+		}
+
+		if len(reverted) == 0 {
+			return ErrNoMigrations
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+func (m *Migrator) Status(ctx context.Context) (statuses []MigrationStatus, err error) {
+
+	err = m.withLock(ctx, func(conn *sql.Conn) error {
+		done, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			appliedAt, ok := done[migration.Version]
+			statuses = append(statuses, MigrationStatus{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				Applied:   ok,
+				AppliedAt: appliedAt,
+			})
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := conn.Close(); closeErr != nil {
+			log.Printf("%s: %s", ErrConnectToDB, closeErr)
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, unlockErr := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+		if unlockErr != nil {
+			log.Printf("%s: %s", ErrConnectToDB, unlockErr)
+		}
+	}()
+
+	query := "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, name VARCHAR(100) NOT NULL, applied_at TIMESTAMPTZ NOT NULL DEFAULT now())"
+
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+// appliedVersions не перезаписывает ошибку в defer: потерянная ошибка чтения
+// выглядела бы как пустая схема, и Up стал бы применять все миграции заново.
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+
+	var version int
+	var appliedAt time.Time
+
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+
+	for rows.Next() {
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		versions[version] = appliedAt
+	}
+
+	return versions, rows.Err()
+}
+
+func runInTx(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...interface{}) error {
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, bookkeeping, args...)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS ledger;
+DROP FUNCTION IF EXISTS ledger_append_only();
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS withdrawals;
@@ -0,0 +1,64 @@
+CREATE TABLE IF NOT EXISTS withdrawals (
+    id SERIAL PRIMARY KEY,
+    number BIGINT,
+    sum NUMERIC(14, 2),
+    processed_at VARCHAR(50),
+    user_login VARCHAR(20)
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    user_login VARCHAR(100),
+    passwd VARCHAR(100)
+);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id SERIAL PRIMARY KEY,
+    user_login VARCHAR(100),
+    order_number BIGINT,
+    status VARCHAR(10),
+    accrual NUMERIC(14, 2),
+    uploaded_at VARCHAR(50)
+);
+
+CREATE TABLE IF NOT EXISTS ledger (
+    id SERIAL PRIMARY KEY,
+    user_login VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    amount NUMERIC(14, 2) NOT NULL,
+    order_number BIGINT,
+    ref_id INTEGER REFERENCES ledger (id),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- базы, созданные до появления миграций, хранили суммы во FLOAT
+ALTER TABLE withdrawals ALTER COLUMN sum TYPE NUMERIC(14, 2);
+ALTER TABLE orders ALTER COLUMN accrual TYPE NUMERIC(14, 2);
+ALTER TABLE ledger ALTER COLUMN amount TYPE NUMERIC(14, 2);
+
+CREATE INDEX IF NOT EXISTS ledger_user_login_created_at_idx ON ledger (user_login, created_at);
+
+CREATE OR REPLACE FUNCTION ledger_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ledger_append_only ON ledger;
+CREATE TRIGGER ledger_append_only BEFORE UPDATE OR DELETE ON ledger
+    FOR EACH ROW EXECUTE FUNCTION ledger_append_only();
+
+-- перенос балансов из старых колонок users.balance и users.withdraw в журнал
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'users' AND column_name = 'balance') THEN
+        INSERT INTO ledger (user_login, kind, amount)
+            SELECT user_login, 'adjustment', COALESCE(balance, 0) + COALESCE(withdraw, 0) FROM users
+            WHERE COALESCE(balance, 0) + COALESCE(withdraw, 0) <> 0;
+        INSERT INTO ledger (user_login, kind, amount)
+            SELECT user_login, 'withdrawal', -withdraw FROM users
+            WHERE COALESCE(withdraw, 0) <> 0;
+        ALTER TABLE users DROP COLUMN balance, DROP COLUMN withdraw;
+    END IF;
+END
+$$;
@@ -0,0 +1 @@
+ALTER TABLE users ALTER COLUMN passwd TYPE VARCHAR(255);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_login VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_login_idx ON sessions (user_login);
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions (expires_at);
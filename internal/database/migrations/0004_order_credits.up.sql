@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS order_credits (
+    order_number BIGINT PRIMARY KEY,
+    user_login VARCHAR(100) NOT NULL,
+    credited_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- повторные начисления, сделанные до появления ограничения, компенсируются сторно
+INSERT INTO ledger (user_login, kind, amount, order_number, ref_id)
+    SELECT l.user_login, 'reversal', -l.amount, l.order_number, l.id
+    FROM ledger l
+    WHERE l.kind = 'accrual'
+      AND l.order_number IS NOT NULL
+      AND EXISTS (
+          SELECT 1 FROM ledger f
+          WHERE f.kind = 'accrual' AND f.order_number = l.order_number AND f.id < l.id
+      )
+      AND NOT EXISTS (SELECT 1 FROM ledger r WHERE r.ref_id = l.id);
+
+INSERT INTO order_credits (order_number, user_login, credited_at)
+    SELECT DISTINCT ON (order_number) order_number, user_login, created_at
+    FROM ledger
+    WHERE kind = 'accrual' AND order_number IS NOT NULL
+    ORDER BY order_number, id
+ON CONFLICT DO NOTHING;
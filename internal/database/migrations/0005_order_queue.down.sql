@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS orders_pending_idx;
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS locked_until,
+    DROP COLUMN IF EXISTS next_attempt_at;
@@ -0,0 +1,6 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS locked_until TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS orders_pending_idx ON orders (next_attempt_at)
+    WHERE status IN ('NEW', 'PROCESSING');
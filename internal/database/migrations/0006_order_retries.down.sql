@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS orders_stalled_idx;
+
+DROP INDEX IF EXISTS orders_pending_idx;
+CREATE INDEX orders_pending_idx ON orders (next_attempt_at)
+    WHERE status IN ('NEW', 'PROCESSING');
+
+ALTER TABLE orders
+    DROP COLUMN IF EXISTS stalled,
+    DROP COLUMN IF EXISTS last_error,
+    DROP COLUMN IF EXISTS attempts;
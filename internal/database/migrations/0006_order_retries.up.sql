@@ -0,0 +1,10 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_error TEXT,
+    ADD COLUMN IF NOT EXISTS stalled BOOLEAN NOT NULL DEFAULT false;
+
+DROP INDEX IF EXISTS orders_pending_idx;
+CREATE INDEX orders_pending_idx ON orders (next_attempt_at)
+    WHERE status IN ('NEW', 'PROCESSING') AND NOT stalled;
+
+CREATE INDEX IF NOT EXISTS orders_stalled_idx ON orders (id) WHERE stalled;
@@ -0,0 +1,2 @@
+ALTER TABLE orders
+    ADD COLUMN IF NOT EXISTS amount NUMERIC(14,2) NOT NULL DEFAULT 0;
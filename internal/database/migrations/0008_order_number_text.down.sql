@@ -0,0 +1,7 @@
+-- номера длиннее 19 цифр не вернуть в BIGINT, откат на них упадет.
+-- user_login в withdrawals остается VARCHAR(100): логины длиннее 20 символов уже допустимы
+
+ALTER TABLE order_credits ALTER COLUMN order_number TYPE BIGINT USING order_number::bigint;
+ALTER TABLE ledger ALTER COLUMN order_number TYPE BIGINT USING order_number::bigint;
+ALTER TABLE withdrawals ALTER COLUMN number TYPE BIGINT USING number::bigint;
+ALTER TABLE orders ALTER COLUMN order_number TYPE BIGINT USING order_number::bigint;
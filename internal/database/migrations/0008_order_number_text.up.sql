@@ -0,0 +1,8 @@
+-- номера заказов произвольной длины не помещаются в BIGINT
+ALTER TABLE orders ALTER COLUMN order_number TYPE VARCHAR(64) USING order_number::text;
+ALTER TABLE withdrawals ALTER COLUMN number TYPE VARCHAR(64) USING number::text;
+ALTER TABLE ledger ALTER COLUMN order_number TYPE VARCHAR(64) USING order_number::text;
+ALTER TABLE order_credits ALTER COLUMN order_number TYPE VARCHAR(64) USING order_number::text;
+
+-- логин в списаниях был короче, чем в users
+ALTER TABLE withdrawals ALTER COLUMN user_login TYPE VARCHAR(100);
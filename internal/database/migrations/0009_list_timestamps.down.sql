@@ -0,0 +1,15 @@
+DROP INDEX IF EXISTS withdrawals_user_processed_idx;
+DROP INDEX IF EXISTS orders_user_status_uploaded_idx;
+DROP INDEX IF EXISTS orders_user_uploaded_idx;
+
+ALTER TABLE withdrawals
+    ALTER COLUMN processed_at DROP NOT NULL,
+    ALTER COLUMN processed_at DROP DEFAULT,
+    ALTER COLUMN processed_at TYPE VARCHAR(50)
+        USING to_char(processed_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"');
+
+ALTER TABLE orders
+    ALTER COLUMN uploaded_at DROP NOT NULL,
+    ALTER COLUMN uploaded_at DROP DEFAULT,
+    ALTER COLUMN uploaded_at TYPE VARCHAR(50)
+        USING to_char(uploaded_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"');
@@ -0,0 +1,14 @@
+-- даты хранились строками RFC 3339, сортировка и фильтры по ним работали лексикографически
+ALTER TABLE orders
+    ALTER COLUMN uploaded_at TYPE TIMESTAMPTZ USING COALESCE(NULLIF(uploaded_at, '')::timestamptz, now()),
+    ALTER COLUMN uploaded_at SET DEFAULT now(),
+    ALTER COLUMN uploaded_at SET NOT NULL;
+
+ALTER TABLE withdrawals
+    ALTER COLUMN processed_at TYPE TIMESTAMPTZ USING COALESCE(NULLIF(processed_at, '')::timestamptz, now()),
+    ALTER COLUMN processed_at SET DEFAULT now(),
+    ALTER COLUMN processed_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS orders_user_uploaded_idx ON orders (user_login, uploaded_at, id);
+CREATE INDEX IF NOT EXISTS orders_user_status_uploaded_idx ON orders (user_login, status, uploaded_at, id);
+CREATE INDEX IF NOT EXISTS withdrawals_user_processed_idx ON withdrawals (user_login, processed_at, id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS order_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_number VARCHAR(64) NOT NULL,
+    old_status VARCHAR(10),
+    new_status VARCHAR(10) NOT NULL,
+    accrual NUMERIC(14, 2) NOT NULL DEFAULT 0,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS order_history_order_number_idx ON order_history (order_number, id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS idempotency_keys;
+DROP INDEX IF EXISTS withdrawals_user_number_key;
@@ -0,0 +1,36 @@
+-- повторные списания по одному заказу, сделанные до появления ограничения, миграция
+-- не исправляет: деньги и история списаний остаются оператору
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(format('%s/%s: id %s', user_login, number, ids), '; ')
+    INTO duplicates
+    FROM (
+        SELECT user_login, number, string_agg(id::TEXT, ', ' ORDER BY id) AS ids
+        FROM withdrawals
+        GROUP BY user_login, number
+        HAVING count(*) > 1
+    ) d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'повторные списания по одному заказу: %', duplicates
+            USING HINT = 'сторнируйте лишние списания в ledger, уберите их из withdrawals и повторите миграцию';
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS withdrawals_user_number_key ON withdrawals (user_login, number);
+
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    user_login VARCHAR(100) NOT NULL,
+    key VARCHAR(255) NOT NULL,
+    request_hash CHAR(64) NOT NULL,
+    status INTEGER NOT NULL DEFAULT 0,
+    content_type VARCHAR(100) NOT NULL DEFAULT '',
+    body BYTEA,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_login, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS orders_order_number_key;
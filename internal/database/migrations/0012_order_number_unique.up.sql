@@ -0,0 +1,22 @@
+-- один номер заказа - одна строка: иначе SetStatus начисляет баллы случайной из них.
+-- повторы, загруженные до ограничения, миграция не трогает, их разбирает оператор
+DO $$
+DECLARE
+    duplicates TEXT;
+BEGIN
+    SELECT string_agg(format('%s: id %s', order_number, ids), '; ')
+    INTO duplicates
+    FROM (
+        SELECT order_number, string_agg(id::TEXT, ', ' ORDER BY id) AS ids
+        FROM orders
+        GROUP BY order_number
+        HAVING count(*) > 1
+    ) d;
+
+    IF duplicates IS NOT NULL THEN
+        RAISE EXCEPTION 'повторные заказы с одним номером: %', duplicates
+            USING HINT = 'оставьте по одной строке на номер заказа и повторите миграцию';
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS orders_order_number_key ON orders (order_number);
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/storage"
+)
+
+const memoryScheme = "memory://"
+
+type Repository interface {
+	Ping(ctx context.Context) error
+
+	InsertUserWithContext(ctx context.Context, user *storage.User) error
+	CheckUserWithContext(ctx context.Context, user *storage.User) error
+
+	InsertOrderWithContext(ctx context.Context, order *storage.Order) error
+	CheckOrderWithContext(ctx context.Context, order *storage.Order) error
+	GetAllUserOrders(ctx context.Context, login string, page storage.Page) ([]storage.Order, *storage.Cursor, error)
+	GetUserOrder(ctx context.Context, login, number string) (storage.OrderDetail, error)
+	GetAllOrders(ctx context.Context) ([]storage.Order, error)
+	ClaimOrders(ctx context.Context, limit int, lease time.Duration, skip []string) ([]storage.Order, error)
+	ReleaseOrder(ctx context.Context, number string, nextAttempt time.Time) error
+	FailOrder(ctx context.Context, number, reason string, nextAttempt time.Time, stalled bool) error
+	GetStalledOrders(ctx context.Context) ([]storage.Order, error)
+	RequeueOrder(ctx context.Context, number string) error
+	SetStatus(ctx context.Context, order *storage.Order) error
+	UserBalanceUpdater(ctx context.Context, order *storage.Order) error
+
+	Withdraw(ctx context.Context, withdraw *storage.Withdraw) error
+	GetAllWithdraw(ctx context.Context, user *storage.User, page storage.Page) ([]storage.Withdraw, *storage.Cursor, error)
+
+	BeginIdempotency(ctx context.Context, key *storage.IdempotencyKey, staleAfter time.Duration) (*storage.IdempotencyKey, error)
+	CompleteIdempotency(ctx context.Context, key *storage.IdempotencyKey) error
+	DeleteIdempotency(ctx context.Context, user, key string) error
+
+	GetBall(user string) (storage.Points, storage.Points, error)
+	GetBallAt(ctx context.Context, user string, at time.Time) (storage.Points, storage.Points, error)
+	GetLedger(ctx context.Context, user string) ([]storage.LedgerEntry, error)
+	InsertLedgerEntryWithContext(ctx context.Context, entry *storage.LedgerEntry) error
+	ReverseLedgerEntryWithContext(ctx context.Context, id int) error
+}
+
+func IsMemory(cfg *config.Config) bool {
+	return cfg.DB == "" || strings.HasPrefix(cfg.DB, memoryScheme)
+}
+
+func New(cfg *config.Config) (Repository, error) {
+	if IsMemory(cfg) {
+		return NewMemoryDB(cfg)
+	}
+
+	return NewUserDB(cfg)
+}
+
+var (
+	_ Repository = (*UserDB)(nil)
+	_ Repository = (*MemoryDB)(nil)
+)
@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/session"
+	"gophermart/internal/storage"
+)
+
+func NewSessionStore(cfg *config.Config, repo Repository) session.Store {
+	if pg, ok := repo.(*UserDB); ok && cfg.SessionStore != session.StoreMemory {
+		return pg
+	}
+
+	return session.NewMemoryStore()
+}
+
+func (d *UserDB) CreateSession(ctx context.Context, s *storage.Session) error {
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(childCtx, "DELETE FROM sessions WHERE expires_at < now()")
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+	}
+
+	query := "INSERT INTO sessions (id, user_login, created_at, expires_at) VALUES($1, $2, $3, $4);"
+
+	_, err = d.db.ExecContext(childCtx, query,
+		s.ID,
+		s.User,
+		s.CreatedAt,
+		s.ExpiresAt,
+	)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	}
+
+	return nil
+}
+
+func (d *UserDB) GetSession(ctx context.Context, id string) (*storage.Session, error) {
+	var s storage.Session
+
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_login, created_at, expires_at FROM sessions WHERE id = $1 AND expires_at > now()"
+
+	err := d.db.QueryRowContext(childCtx, query, id).Scan(&s.ID, &s.User, &s.CreatedAt, &s.ExpiresAt)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, session.ErrNotFound
+	case err != nil:
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return nil, ErrConnectToDB
+	}
+
+	return &s, nil
+}
+
+func (d *UserDB) TouchSession(ctx context.Context, id string, expiresAt time.Time) error {
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	r, err := d.db.ExecContext(childCtx, "UPDATE sessions SET expires_at = $1 WHERE id = $2", expiresAt, id)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	}
+
+	if n, _ := r.RowsAffected(); n == 0 {
+		return session.ErrNotFound
+	}
+
+	return nil
+}
+
+func (d *UserDB) DeleteSession(ctx context.Context, id string) error {
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(childCtx, "DELETE FROM sessions WHERE id = $1", id)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	}
+
+	return nil
+}
+
+func (d *UserDB) DeleteUserSessions(ctx context.Context, login string) error {
+	childCtx, cancel := context.WithTimeout(ctx, 1*time.Second)
+	defer cancel()
+
+	_, err := d.db.ExecContext(childCtx, "DELETE FROM sessions WHERE user_login = $1", login)
+	if err != nil {
+		log.Printf("%s: %s", ErrConnectToDB, err)
+		return ErrConnectToDB
+	}
+
+	return nil
+}
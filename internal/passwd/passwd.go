@@ -0,0 +1,171 @@
+package passwd
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+
+	"gophermart/internal/config"
+)
+
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+
+	argonSaltLen = 16
+	argonKeyLen  = 32
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("неизвестный алгоритм хеширования пароля")
+	ErrHashFormat       = errors.New("неверный формат хеша пароля")
+)
+
+type Hasher struct {
+	algorithm string
+
+	bcryptCost int
+
+	argonTime    uint32
+	argonMemory  uint32
+	argonThreads uint8
+
+	// dummy - хеш с текущими параметрами для проверки паролей несуществующих логинов
+	dummy string
+}
+
+func NewHasher(cfg *config.Config) (*Hasher, error) {
+	h := &Hasher{
+		algorithm:    cfg.PasswdHash,
+		bcryptCost:   int(cfg.BcryptCost),
+		argonTime:    uint32(cfg.ArgonTime),
+		argonMemory:  uint32(cfg.ArgonMemory),
+		argonThreads: uint8(cfg.ArgonThreads),
+	}
+
+	switch h.algorithm {
+	case Argon2id:
+		if h.argonTime == 0 || h.argonMemory == 0 || h.argonThreads == 0 {
+			return nil, fmt.Errorf("%w: нулевые параметры argon2id", ErrUnknownAlgorithm)
+		}
+	case Bcrypt:
+		if h.bcryptCost < bcrypt.MinCost || h.bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("%w: недопустимая стоимость bcrypt %d", ErrUnknownAlgorithm, h.bcryptCost)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, h.algorithm)
+	}
+
+	dummy, err := h.Hash("dummy-password")
+	if err != nil {
+		return nil, err
+	}
+	h.dummy = dummy
+
+	return h, nil
+}
+
+func (h *Hasher) Hash(password string) (string, error) {
+	switch h.algorithm {
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	default:
+		salt := make([]byte, argonSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+
+		key := argon2.IDKey([]byte(password), salt, h.argonTime, h.argonMemory, h.argonThreads, argonKeyLen)
+
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			argon2.Version, h.argonMemory, h.argonTime, h.argonThreads,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key),
+		), nil
+	}
+}
+
+// Verify сравнивает пароль с сохраненным значением. needsRehash сообщает, что значение
+// хранится открытым текстом, другим алгоритмом или с устаревшими параметрами.
+func (h *Hasher) Verify(encoded, password string) (ok bool, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return h.verifyArgon(encoded, password)
+
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		err = bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, false, nil
+		case err != nil:
+			return false, false, err
+		}
+
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return false, false, err
+		}
+
+		return true, h.algorithm != Bcrypt || cost != h.bcryptCost, nil
+
+	default:
+		ok = subtle.ConstantTimeCompare([]byte(encoded), []byte(password)) == 1
+		return ok, ok, nil
+	}
+}
+
+// VerifyMissing тратит на пароль неизвестного логина столько же времени, сколько Verify
+// на настоящий хеш, чтобы по времени ответа нельзя было подобрать существующие логины.
+func (h *Hasher) VerifyMissing(password string) {
+	_, _, _ = h.Verify(h.dummy, password)
+}
+
+func (h *Hasher) verifyArgon(encoded, password string) (bool, bool, error) {
+	var version int
+	var memory, time uint32
+	var threads uint8
+
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, ErrHashFormat
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrHashFormat
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false, ErrHashFormat
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrHashFormat
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrHashFormat
+	}
+
+	actual := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, actual) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash := h.algorithm != Argon2id ||
+		memory != h.argonMemory || time != h.argonTime || threads != h.argonThreads ||
+		len(key) != argonKeyLen
+
+	return true, needsRehash, nil
+}
@@ -0,0 +1,130 @@
+package passwd
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gophermart/internal/config"
+)
+
+func newTestHasher(t *testing.T, cfg config.Config) *Hasher {
+	t.Helper()
+
+	h, err := NewHasher(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func argonConfig(time uint) config.Config {
+	return config.Config{PasswdHash: Argon2id, ArgonTime: time, ArgonMemory: 1024, ArgonThreads: 1}
+}
+
+func bcryptConfig(cost uint) config.Config {
+	return config.Config{PasswdHash: Bcrypt, BcryptCost: cost}
+}
+
+func TestNewHasher(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Config
+		err  error
+	}{
+		{"argon2id", argonConfig(1), nil},
+		{"bcrypt", bcryptConfig(4), nil},
+		{"неизвестный алгоритм", config.Config{PasswdHash: "md5"}, ErrUnknownAlgorithm},
+		{"нулевые параметры argon2id", config.Config{PasswdHash: Argon2id}, ErrUnknownAlgorithm},
+		{"слишком малая стоимость bcrypt", bcryptConfig(1), ErrUnknownAlgorithm},
+	}
+
+	for _, tt := range tests {
+		cfg := tt.cfg
+		if _, err := NewHasher(&cfg); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestHashVerify(t *testing.T) {
+	const password = "Secret123"
+
+	tests := []struct {
+		name   string
+		hash   config.Config
+		verify config.Config
+		prefix string
+		rehash bool
+	}{
+		{"argon2id", argonConfig(1), argonConfig(1), "$argon2id$", false},
+		{"bcrypt", bcryptConfig(4), bcryptConfig(4), "$2a$", false},
+		{"argon2id с другими параметрами", argonConfig(1), argonConfig(2), "$argon2id$", true},
+		{"bcrypt с другой стоимостью", bcryptConfig(4), bcryptConfig(5), "$2a$", true},
+		{"bcrypt после перехода на argon2id", bcryptConfig(4), argonConfig(1), "$2a$", true},
+		{"argon2id после перехода на bcrypt", argonConfig(1), bcryptConfig(4), "$argon2id$", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := newTestHasher(t, tt.hash).Hash(password)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(encoded, tt.prefix) {
+				t.Fatalf("хеш %q, want префикс %q", encoded, tt.prefix)
+			}
+
+			h := newTestHasher(t, tt.verify)
+
+			ok, rehash, err := h.Verify(encoded, password)
+			if err != nil || !ok || rehash != tt.rehash {
+				t.Errorf("Verify(верный пароль) = %v, %v, %v, want true, %v, nil", ok, rehash, err, tt.rehash)
+			}
+
+			ok, rehash, err = h.Verify(encoded, password+"x")
+			if err != nil || ok || rehash {
+				t.Errorf("Verify(неверный пароль) = %v, %v, %v, want false, false, nil", ok, rehash, err)
+			}
+		})
+	}
+}
+
+func TestVerifyLegacy(t *testing.T) {
+	h := newTestHasher(t, bcryptConfig(4))
+
+	tests := []struct {
+		stored, password string
+		ok, rehash       bool
+		err              error
+	}{
+		{"Secret123", "Secret123", true, true, nil},
+		{"Secret123", "secret123", false, false, nil},
+		{"", "", true, true, nil},
+		{"$argon2id$v=19$m=1024$bad", "Secret123", false, false, ErrHashFormat},
+		{"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5", "Secret123", false, false, ErrHashFormat},
+	}
+
+	for _, tt := range tests {
+		ok, rehash, err := h.Verify(tt.stored, tt.password)
+		if ok != tt.ok || rehash != tt.rehash || !errors.Is(err, tt.err) {
+			t.Errorf("Verify(%q, %q) = %v, %v, %v, want %v, %v, %v",
+				tt.stored, tt.password, ok, rehash, err, tt.ok, tt.rehash, tt.err)
+		}
+	}
+}
+
+func TestVerifyMissingUsesCurrentParams(t *testing.T) {
+	for _, cfg := range []config.Config{argonConfig(1), bcryptConfig(4)} {
+		h := newTestHasher(t, cfg)
+
+		// фиктивный хеш проверяется так же, как настоящий, без пересчета
+		ok, rehash, err := h.Verify(h.dummy, "dummy-password")
+		if err != nil || !ok || rehash {
+			t.Errorf("%s: фиктивный хеш = %v, %v, %v", cfg.PasswdHash, ok, rehash, err)
+		}
+
+		h.VerifyMissing("Secret123")
+	}
+}
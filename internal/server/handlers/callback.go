@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"io"
+	"log"
+	"net/http"
+
+	"gophermart/internal/client"
+	"gophermart/internal/server/problem"
+)
+
+// максимальный размер тела обратного вызова системы расчета
+const callbackBodyLimit = 64 << 10
+
+func (h *Handler) AccrualCallback(w http.ResponseWriter, r *http.Request) {
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, callbackBodyLimit))
+	if err != nil {
+		log.Printf("%s: %s", ErrBodyRead, err)
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, ErrBodyRead.Error())
+		return
+	}
+
+	err = h.accrual.Callback(r.Context(), body, r.Header.Get(client.SignatureHeader))
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
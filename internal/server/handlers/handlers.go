@@ -4,17 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
 	"time"
 
-	"github.com/theplant/luhn"
+	"gophermart/internal/client"
 	"gophermart/internal/config"
 	"gophermart/internal/cookies"
 	"gophermart/internal/database"
+	"gophermart/internal/server/problem"
+	"gophermart/internal/server/validate"
 	"gophermart/internal/storage"
+	"gophermart/internal/tokens"
 
 	gctx "github.com/gorilla/context"
 )
@@ -25,16 +29,31 @@ var (
 	ErrBodyClose = errors.New("неудалось закрыть тело запроса")
 )
 
+type orderUpload struct {
+	Number string      `json:"number"`
+	Amount json.Number `json:"amount"`
+}
+
+// withdrawRequest хранит сумму как есть, чтобы проверить точность до округления.
+type withdrawRequest struct {
+	Order string      `json:"order"`
+	Sum   json.Number `json:"sum"`
+}
+
 type Handler struct {
-	db      *database.UserDB
+	db      database.Repository
 	cookies *cookies.CookieManager
+	tokens  *tokens.Manager
+	accrual *client.Client
 	cfg     *config.Config
 }
 
-func NewHandler(db *database.UserDB, cookies *cookies.CookieManager, cfg *config.Config) *Handler {
+func NewHandler(db database.Repository, cookies *cookies.CookieManager, tokens *tokens.Manager, accrual *client.Client, cfg *config.Config) *Handler {
 	return &Handler{
 		db:      db,
 		cookies: cookies,
+		tokens:  tokens,
+		accrual: accrual,
 		cfg:     cfg,
 	}
 }
@@ -45,39 +64,34 @@ func (h *Handler) AllWithdrawals(w http.ResponseWriter, r *http.Request) {
 	var resp []byte
 	var err error
 	var user storage.User
-	var withdrawalsList []storage.Withdraw
 
 	user.Login = gctx.Get(r, "login").(string)
-	log.Println("middleware has worked.... login is ", user.Login)
 
-	withdrawalsList, err = h.db.GetAllWithdraw(ctx, &user)
-	switch err {
-	case database.ErrConnectToDB:
-		w.WriteHeader(http.StatusInternalServerError)
+	var v validate.Validator
+	page := v.Page(r.URL.Query())
+	if err = v.Err(); err != nil {
+		problem.Error(w, r, err)
 		return
+	}
+
+	withdrawalsList, next, err := h.db.GetAllWithdraw(ctx, &user, page)
+	switch err {
 	case nil:
 		if len(withdrawalsList) == 0 {
-			w.Header().Set("Content-Type", "application/json")
-			_, _ = w.Write(resp)
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
-		log.Println(withdrawalsList)
 		resp, err = json.Marshal(withdrawalsList)
 		if err != nil {
-			log.Printf("%e: %e", ErrUnmarshal, err)
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.Error(w, r, fmt.Errorf("%w: %s", ErrUnmarshal, err))
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		_, _ = w.Write(resp)
-		w.WriteHeader(http.StatusOK)
+		writeList(w, resp, next)
 		return
 	default:
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 }
@@ -88,40 +102,18 @@ func (h *Handler) Balance(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var body []byte
 
-	cookieA := r.Cookies()
-	user.Login, err = h.cookies.CheckCookie(&user, cookieA)
-
-	switch {
-	case err == database.ErrConnectToDB:
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	case err == database.ErrRowDoesntExists:
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	case err == cookies.ErrNoCookie:
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	case err == cookies.ErrInvalidValue:
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	case err != nil:
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	default:
-	}
+	user.Login = gctx.Get(r, "login").(string)
 
 	user.Balance, user.Withdraw, err = h.db.GetBall(user.Login)
 	if err != nil {
-		log.Printf("%s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	}
 
 	log.Println(user)
 	body, err = json.Marshal(user)
 	if err != nil {
-		log.Printf("%e: %e", ErrUnmarshal, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Error(w, r, fmt.Errorf("%w: %s", ErrUnmarshal, err))
 		return
 	}
 
@@ -137,53 +129,52 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.Background()
 
-	body, err := io.ReadAll(r.Body)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, validate.AuthBodyLimit))
 	if err != nil {
-		log.Printf("%s", ErrBodyRead)
-		w.WriteHeader(http.StatusBadRequest)
+		h.bodyError(w, r, err)
 		return
 	}
 	defer func() {
 		err = r.Body.Close()
 		if err != nil {
 			log.Printf("%s", ErrBodyClose)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
 		}
 	}()
 
 	err = json.Unmarshal(body, &user)
 	if err != nil {
-		log.Printf("%e", ErrUnmarshal)
-		w.WriteHeader(http.StatusBadRequest)
+		log.Printf("%s", ErrUnmarshal)
+		problem.WriteDetail(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, ErrUnmarshal.Error(), err.Error())
 		return
 	}
 
-	err = h.db.InsertUserWithContext(ctx, &user)
-	if err != nil {
-		log.Printf("%s", database.ErrConnectToDB)
-		w.WriteHeader(http.StatusConflict)
+	var v validate.Validator
+	v.Login("login", user.Login)
+	v.Password("password", user.Passwd)
+	if err = v.Err(); err != nil {
+		problem.Error(w, r, err)
 		return
 	}
 
-	cookie, err = h.cookies.GetCookie(&user)
-
+	err = h.db.InsertUserWithContext(ctx, &user)
 	switch err {
-	case cookies.ErrValueTooLong:
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	case cookies.ErrCipher:
-		w.WriteHeader(http.StatusInternalServerError)
-		return
 	case nil:
-		http.SetCookie(w, cookie)
-		w.WriteHeader(http.StatusOK)
+	case database.ErrRowAlreadyExists:
+		problem.Write(w, r, http.StatusConflict, problem.CodeLoginTaken, "логин уже занят")
 		return
 	default:
-		log.Printf("неизвестная ошибка %s", err)
+		problem.Error(w, r, err)
+		return
+	}
+
+	cookie, err = h.cookies.GetCookie(&user)
+	if err != nil {
+		problem.Error(w, r, err)
 		return
 	}
 
+	http.SetCookie(w, cookie)
+	h.writeTokens(w, r, user.Login)
 }
 
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
@@ -193,92 +184,178 @@ func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 
 	ctx := context.Background()
 
-	body, err := io.ReadAll(r.Body)
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, validate.AuthBodyLimit))
 	if err != nil {
-		log.Printf("Ошибка чтения тела запроса: \n%e", err)
-		w.WriteHeader(http.StatusBadRequest)
+		log.Printf("Ошибка чтения тела запроса: \n%s", err)
+		h.bodyError(w, r, err)
 		return
 	}
 	defer func() {
 		err = r.Body.Close()
 		if err != nil {
-			log.Printf("Не удалось закрыть тело запроса: \n%e", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			log.Printf("Не удалось закрыть тело запроса: \n%s", err)
 		}
 	}()
 
 	if len(body) > 0 {
 		err = json.Unmarshal(body, &user)
 		if err != nil {
-			log.Printf("Ошибка перевода из формата json: \n%e", err)
-			w.WriteHeader(http.StatusBadRequest)
+			log.Printf("Ошибка перевода из формата json: \n%s", err)
+			problem.WriteDetail(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, ErrUnmarshal.Error(), err.Error())
 			return
 		}
+
+		// правила регистрации не применяются, чтобы не закрыть вход старым пользователям
+		var v validate.Validator
+		v.Required("login", user.Login)
+		v.Required("password", user.Passwd)
+		if err = v.Err(); err != nil {
+			problem.Error(w, r, err)
+			return
+		}
+
 		err = h.db.CheckUserWithContext(ctx, &user)
 		switch err {
-		case database.ErrConnectToDB:
-			w.WriteHeader(http.StatusInternalServerError)
-			return
 		case database.ErrRowDoesntExists:
-			w.WriteHeader(http.StatusUnauthorized)
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials, "неверный логин или пароль")
 			return
 		case nil:
 			cookie, err = h.cookies.GetCookie(&user)
 			if err != nil {
-				log.Println(err)
-				w.WriteHeader(http.StatusInternalServerError)
+				problem.Error(w, r, err)
 				return
 			}
 
 			http.SetCookie(w, cookie)
-			w.WriteHeader(http.StatusOK)
+			h.writeTokens(w, r, user.Login)
+			return
+		default:
+			problem.Error(w, r, err)
 			return
 		}
-
 	}
 
 	cookieA := r.Cookies()
-	_, err = h.cookies.CheckCookie(&user, cookieA)
+	user.Login, err = h.cookies.CheckCookie(&user, cookieA)
 
 	switch {
-	case err == database.ErrConnectToDB:
-		log.Printf("Ошибка: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
 	case err == database.ErrRowDoesntExists:
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	case err == cookies.ErrNoCookie:
-		w.WriteHeader(http.StatusUnauthorized)
-		return
-	case err == cookies.ErrInvalidValue:
-		w.WriteHeader(http.StatusBadRequest)
+		problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, cookies.ErrSessionEnded.Error())
 		return
 	case err != nil:
-		log.Printf("Ошибка: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Error(w, r, err)
 		return
 	default:
 	}
 
 	cookie, err = h.cookies.GetCookie(&user)
-	switch err {
-	case cookies.ErrValueTooLong:
-		w.WriteHeader(http.StatusBadRequest)
+	if err != nil {
+		problem.Error(w, r, err)
 		return
-	case cookies.ErrCipher:
-		w.WriteHeader(http.StatusInternalServerError)
+	}
+
+	http.SetCookie(w, cookie)
+	h.writeTokens(w, r, user.Login)
+}
+
+func (h *Handler) writeTokens(w http.ResponseWriter, r *http.Request, login string) {
+
+	pair, err := h.tokens.Issue(context.Background(), login)
+	if err != nil {
+		problem.Error(w, r, err)
 		return
-	case nil:
+	}
+
+	body, err := json.Marshal(pair)
+	if err != nil {
+		problem.Error(w, r, fmt.Errorf("%w: %s", ErrUnmarshal, err))
+		return
+	}
+
+	w.Header().Set("Authorization", pair.TokenType+" "+pair.AccessToken)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func (h *Handler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, validate.AuthBodyLimit))
+	if err != nil {
+		h.bodyError(w, r, err)
+		return
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil || req.RefreshToken == "" {
+		problem.Write(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, "нужен refresh_token")
+		return
+	}
+
+	pair, err := h.tokens.Refresh(r.Context(), req.RefreshToken)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	body, err = json.Marshal(pair)
+	if err != nil {
+		problem.Error(w, r, fmt.Errorf("%w: %s", ErrUnmarshal, err))
+		return
+	}
+
+	w.Header().Set("Authorization", pair.TokenType+" "+pair.AccessToken)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func (h *Handler) Logout(w http.ResponseWriter, r *http.Request) {
+
+	err := h.tokens.Revoke(r)
+	if err != nil && err != tokens.ErrNoToken {
+		problem.Error(w, r, err)
+		return
+	}
+
+	expired, err := h.cookies.Logout(r.Cookies())
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	for _, cookie := range expired {
 		http.SetCookie(w, cookie)
-		w.WriteHeader(http.StatusOK)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) LogoutAll(w http.ResponseWriter, r *http.Request) {
+
+	login := gctx.Get(r, "login").(string)
+
+	err := h.cookies.LogoutAll(login)
+	if err != nil {
+		problem.Error(w, r, err)
 		return
-	default:
-		log.Printf("неизвестная ошибка %s", err)
+	}
+
+	expired, err := h.cookies.Logout(r.Cookies())
+	if err != nil {
+		problem.Error(w, r, err)
 		return
 	}
 
+	for _, cookie := range expired {
+		http.SetCookie(w, cookie)
+	}
+
+	w.WriteHeader(http.StatusOK)
 }
 
 func (h *Handler) Orders(w http.ResponseWriter, r *http.Request) {
@@ -290,58 +367,82 @@ func (h *Handler) Orders(w http.ResponseWriter, r *http.Request) {
 	ctx := context.Background()
 
 	order.User = gctx.Get(r, "login").(string)
-	order.Status = "NEW"
+	order.Status = storage.StatusNew
 	order.UploadedAt = time.Now().Format(time.RFC3339)
 
-	body, err = io.ReadAll(r.Body)
+	body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, validate.OrderBodyLimit))
 	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
+		h.bodyError(w, r, err)
 		return
 	}
 
-	order.Number = string(body)
+	order.Number = strings.TrimSpace(string(body))
 
-	err = h.luhnCheck(order.Number)
-	if err != nil {
-		log.Printf("%s", err)
-		w.WriteHeader(http.StatusUnprocessableEntity)
+	// сумма заказа нужна провайдерам, которые начисляют процент от нее
+	var v validate.Validator
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var upload orderUpload
+
+		if err = json.Unmarshal(body, &upload); err != nil {
+			problem.WriteDetail(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, ErrUnmarshal.Error(), err.Error())
+			return
+		}
+
+		order.Number = upload.Number
+		if upload.Amount != "" {
+			order.Amount = v.Sum("amount", upload.Amount)
+		}
+	}
+
+	v.OrderNumber("number", order.Number)
+	if err = v.Err(); err != nil {
+		problem.Error(w, r, err)
 		return
 	}
 
 	err = h.db.CheckOrderWithContext(ctx, &order)
 
 	switch err {
-	case database.ErrConnectToDB:
-		log.Printf("Ошибка: %s", err)
-		w.WriteHeader(http.StatusInternalServerError)
+	case database.ErrConnectToDB, database.ErrRowWasCreatedAnyUser:
+		problem.Error(w, r, err)
 		return
 	case database.ErrRowAlreadyExists:
 		w.WriteHeader(http.StatusOK)
 		return
-	case database.ErrRowWasCreatedAnyUser:
-		w.WriteHeader(http.StatusConflict)
-		return
 	default:
 	}
 
 	err = h.db.InsertOrderWithContext(ctx, &order)
-	if err != nil {
+	switch err {
+	case nil:
+	case database.ErrRowAlreadyExists:
+		w.WriteHeader(http.StatusOK)
+		return
+	case database.ErrRowWasCreatedAnyUser:
+		problem.Error(w, r, err)
+		return
+	default:
 		log.Printf("%s: %s", database.ErrConnectToDB, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.Error(w, r, database.ErrConnectToDB)
 		return
-
 	}
 
+	h.accrual.Notify()
+
 	w.WriteHeader(http.StatusAccepted)
 }
 
-func (h *Handler) luhnCheck(number string) error {
-	tmp, _ := strconv.Atoi(number)
-	if isValid := luhn.Valid(tmp); !isValid {
-		return database.ErrNumberFormat
+// bodyError отличает слишком большое тело от прочих ошибок чтения.
+func (h *Handler) bodyError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		problem.Error(w, r, err)
+		return
 	}
-	return nil
+
+	log.Printf("%s: %s", ErrBodyRead, err)
+	problem.Write(w, r, http.StatusBadRequest, problem.CodeBadRequest, ErrBodyRead.Error())
 }
 
 func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
@@ -354,46 +455,47 @@ func (h *Handler) Withdraw(w http.ResponseWriter, r *http.Request) {
 
 	withdraw.User = gctx.Get(r, "login").(string)
 
-	body, err = io.ReadAll(r.Body)
+	body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, validate.WithdrawBodyLimit))
 	if err != nil {
-		log.Printf("%s: %s", ErrBodyRead, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		h.bodyError(w, r, err)
 		return
 	}
 	defer func() {
 		err = r.Body.Close()
 		if err != nil {
-			log.Printf("%s", ErrBodyRead)
-			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("%s", ErrBodyClose)
 		}
 	}()
 
-	err = json.Unmarshal(body, &withdraw)
+	var req withdrawRequest
+
+	err = json.Unmarshal(body, &req)
 	if err != nil {
 		log.Printf("%s: %s", ErrUnmarshal, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		problem.WriteDetail(w, r, http.StatusBadRequest, problem.CodeInvalidJSON, ErrUnmarshal.Error(), err.Error())
 		return
 	}
 
-	err = h.luhnCheck(withdraw.NumberOrder)
-	if err != nil {
-		log.Printf("%s", err)
-		w.WriteHeader(http.StatusUnprocessableEntity)
+	var v validate.Validator
+	v.OrderNumber("order", req.Order)
+	withdraw.Sum = v.Sum("sum", req.Sum)
+	if err = v.Err(); err != nil {
+		problem.Error(w, r, err)
 		return
 	}
+	withdraw.NumberOrder = req.Order
 
 	withdraw.ProcessedAt = time.Now().Format(time.RFC3339)
 
 	err = h.db.Withdraw(ctx, &withdraw)
 	switch err {
-	case database.ErrConnectToDB:
-		log.Printf("%s: %s", database.ErrConnectToDB, err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	case database.ErrNotEnoughMoney:
-		w.WriteHeader(http.StatusPaymentRequired)
+	case nil:
+	case database.ErrRowAlreadyExists:
+		problem.Write(w, r, http.StatusConflict, problem.CodeWithdrawalExists, "списание по этому заказу уже было")
 		return
 	default:
+		problem.Error(w, r, err)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -405,34 +507,48 @@ func (h *Handler) AllOrder(w http.ResponseWriter, r *http.Request) {
 
 	var resp []byte
 	var err error
-	var orderList []storage.Order
 
 	login := gctx.Get(r, "login").(string)
 
-	orderList, err = h.db.GetAllUserOrders(ctx, login)
-	switch err {
-	case database.ErrConnectToDB:
-		w.WriteHeader(http.StatusInternalServerError)
+	var v validate.Validator
+	page := v.Page(r.URL.Query(),
+		storage.StatusNew, storage.StatusProcessing, storage.StatusInvalid, storage.StatusProcessed,
+	)
+	if err = v.Err(); err != nil {
+		problem.Error(w, r, err)
 		return
+	}
+
+	orderList, next, err := h.db.GetAllUserOrders(ctx, login, page)
+	switch err {
 	case nil:
 		if len(orderList) == 0 {
-			w.Header().Set("Content-Type", "application/json")
-			_, _ = w.Write(resp)
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
 		resp, err = json.Marshal(orderList)
 		if err != nil {
-			log.Printf("%e: %e", ErrUnmarshal, err)
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.Error(w, r, fmt.Errorf("%w: %s", ErrUnmarshal, err))
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		_, _ = w.Write(resp)
-		w.WriteHeader(http.StatusOK)
+		writeList(w, resp, next)
 		return
+	default:
+		problem.Error(w, r, err)
+		return
+	}
+}
+
+// writeList отдает страницу списка, курсор следующей страницы - в заголовке,
+// чтобы тело осталось массивом.
+func writeList(w http.ResponseWriter, body []byte, next *storage.Cursor) {
+	if next != nil {
+		w.Header().Set(validate.NextCursorHeader, next.Encode())
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
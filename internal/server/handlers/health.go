@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"gophermart/internal/client"
+	"gophermart/internal/server/problem"
+)
+
+type health struct {
+	Status   string `json:"status"`
+	Database string `json:"database"`
+	Accrual  string `json:"accrual"`
+}
+
+func (h *Handler) Health(w http.ResponseWriter, r *http.Request) {
+
+	resp := health{
+		Status:   "ok",
+		Database: "ok",
+		Accrual:  h.accrual.Stats().BreakerState,
+	}
+	code := http.StatusOK
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	if err := h.db.Ping(ctx); err != nil {
+		resp.Status = "down"
+		resp.Database = "error"
+		code = http.StatusServiceUnavailable
+	} else if resp.Accrual != client.StateClosed {
+		resp.Status = "degraded"
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		problem.Error(w, r, fmt.Errorf("%w: %s", ErrUnmarshal, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
+
+func (h *Handler) Metrics(w http.ResponseWriter, r *http.Request) {
+
+	stats := h.accrual.Stats()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# TYPE gophermart_accrual_requests_total counter")
+	fmt.Fprintf(w, "gophermart_accrual_requests_total %d\n", stats.Requests)
+	fmt.Fprintln(w, "# TYPE gophermart_accrual_failures_total counter")
+	fmt.Fprintf(w, "gophermart_accrual_failures_total %d\n", stats.Failures)
+	fmt.Fprintln(w, "# TYPE gophermart_accrual_breaker_rejected_total counter")
+	fmt.Fprintf(w, "gophermart_accrual_breaker_rejected_total %d\n", stats.Rejected)
+	fmt.Fprintln(w, "# TYPE gophermart_accrual_breaker_state gauge")
+	for _, state := range []string{client.StateClosed, client.StateOpen, client.StateHalfOpen} {
+		value := 0
+		if state == stats.BreakerState {
+			value = 1
+		}
+		fmt.Fprintf(w, "gophermart_accrual_breaker_state{state=%q} %d\n", state, value)
+	}
+}
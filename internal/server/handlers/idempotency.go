@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	gctx "github.com/gorilla/context"
+
+	"gophermart/internal/server/problem"
+	"gophermart/internal/storage"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	idempotencyKeyMaxLen = 255
+
+	// idempotencyStale - через сколько незавершенный запрос считается
+	// оборвавшимся, и ключ можно занять заново.
+	idempotencyStale = time.Minute
+
+	// idempotencyBodyLimit только защищает память, свой лимит обработчик проверит сам.
+	idempotencyBodyLimit = 64 << 10
+)
+
+// Idempotent сохраняет ответ next по заголовку Idempotency-Key на IdempotencyTTL.
+// Повтор запроса с тем же ключом и телом получает сохраненный ответ, next не вызывается.
+func (h *Handler) Idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		if len(key) > idempotencyKeyMaxLen {
+			problem.Write(w, r, http.StatusBadRequest, problem.CodeIdempotencyKey, "ключ Idempotency-Key длиннее 255 символов")
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, idempotencyBodyLimit))
+		if err != nil {
+			h.bodyError(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		sum := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(body)))
+
+		rec := storage.IdempotencyKey{
+			User:        gctx.Get(r, "login").(string),
+			Key:         key,
+			RequestHash: hex.EncodeToString(sum[:]),
+			ExpiresAt:   time.Now().Add(h.cfg.IdempotencyTTL),
+		}
+
+		stored, err := h.db.BeginIdempotency(r.Context(), &rec, idempotencyStale)
+		if err != nil {
+			problem.Error(w, r, err)
+			return
+		}
+
+		switch {
+		case stored == nil:
+		case stored.RequestHash != rec.RequestHash:
+			problem.Write(w, r, http.StatusUnprocessableEntity, problem.CodeIdempotencyReuse, "ключ Idempotency-Key уже использован с другим запросом")
+			return
+		case stored.Status == 0:
+			problem.Write(w, r, http.StatusConflict, problem.CodeIdempotencyPending, "запрос с этим ключом Idempotency-Key еще выполняется")
+			return
+		default:
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set(IdempotencyReplayedHeader, "true")
+			w.WriteHeader(stored.Status)
+			_, _ = w.Write(stored.Body)
+			return
+		}
+
+		rw := &recorder{ResponseWriter: w}
+		next(rw, r)
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+
+		// ключ не сохраняем после серверной ошибки, чтобы повтор выполнился заново
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		if rw.status >= http.StatusInternalServerError {
+			err = h.db.DeleteIdempotency(ctx, rec.User, rec.Key)
+		} else {
+			rec.Status = rw.status
+			rec.ContentType = rw.Header().Get("Content-Type")
+			rec.Body = rw.body.Bytes()
+			err = h.db.CompleteIdempotency(ctx, &rec)
+		}
+		if err != nil {
+			log.Printf("ключ %s пользователя %s: %s", rec.Key, rec.User, err)
+		}
+	}
+}
+
+// recorder пропускает ответ клиенту и запоминает его для повтора.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rw *recorder) WriteHeader(status int) {
+	if rw.status != 0 {
+		return
+	}
+	rw.status = status
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recorder) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	rw.body.Write(b)
+	return rw.ResponseWriter.Write(b)
+}
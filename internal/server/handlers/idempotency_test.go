@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gctx "github.com/gorilla/context"
+
+	"gophermart/internal/config"
+	"gophermart/internal/database"
+	"gophermart/internal/server/problem"
+)
+
+func newTestHandler(t *testing.T, ttl time.Duration) *Handler {
+	t.Helper()
+
+	cfg := &config.Config{PasswdHash: "bcrypt", BcryptCost: 4, IdempotencyTTL: ttl}
+
+	db, err := database.NewMemoryDB(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Handler{db: db, cfg: cfg}
+}
+
+// call выполняет запрос пользователя user1 с ключом key, пустой key - без заголовка.
+func call(h http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(IdempotencyKeyHeader, key)
+	}
+
+	gctx.Set(r, "login", "user1")
+	defer gctx.Clear(r)
+
+	w := httptest.NewRecorder()
+	h(w, r)
+
+	return w
+}
+
+func problemCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var p problem.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+		t.Fatalf("тело ответа %q: %s", w.Body.String(), err)
+	}
+
+	return p.Code
+}
+
+func TestIdempotent(t *testing.T) {
+	const body = `{"order":"2377225624","sum":100}`
+
+	t.Run("повтор получает сохраненный ответ", func(t *testing.T) {
+		h := newTestHandler(t, time.Hour)
+
+		calls := 0
+		next := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte(`{"n":1}`))
+		})
+
+		first := call(next, "k1", body)
+		second := call(next, "k1", body)
+
+		if calls != 1 {
+			t.Errorf("обработчик вызван %d раз, want 1", calls)
+		}
+		if second.Code != first.Code || second.Body.String() != first.Body.String() ||
+			second.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("повтор = %d %q, want %d %q", second.Code, second.Body, first.Code, first.Body)
+		}
+		if first.Header().Get(IdempotencyReplayedHeader) != "" || second.Header().Get(IdempotencyReplayedHeader) != "true" {
+			t.Errorf("заголовок %s: %q, %q", IdempotencyReplayedHeader,
+				first.Header().Get(IdempotencyReplayedHeader), second.Header().Get(IdempotencyReplayedHeader))
+		}
+
+		// ключи принадлежат пользователю, без ключа запрос выполняется всегда
+		call(next, "", body)
+		call(next, "", body)
+		if calls != 3 {
+			t.Errorf("запросы без ключа: обработчик вызван %d раз, want 3", calls)
+		}
+	})
+
+	t.Run("тот же ключ с другим телом", func(t *testing.T) {
+		h := newTestHandler(t, time.Hour)
+		next := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+
+		call(next, "k1", body)
+		w := call(next, "k1", `{"order":"2377225624","sum":200}`)
+
+		if w.Code != http.StatusUnprocessableEntity || problemCode(t, w) != problem.CodeIdempotencyReuse {
+			t.Errorf("ответ %d %s, want 422 %s", w.Code, w.Body, problem.CodeIdempotencyReuse)
+		}
+	})
+
+	t.Run("исходный запрос еще выполняется", func(t *testing.T) {
+		h := newTestHandler(t, time.Hour)
+
+		var inner *httptest.ResponseRecorder
+		var next http.HandlerFunc
+		next = h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+			if inner == nil {
+				inner = call(next, "k1", body)
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+
+		call(next, "k1", body)
+
+		if inner.Code != http.StatusConflict || problemCode(t, inner) != problem.CodeIdempotencyPending {
+			t.Errorf("ответ %d %s, want 409 %s", inner.Code, inner.Body, problem.CodeIdempotencyPending)
+		}
+	})
+
+	t.Run("ответ 5xx не сохраняется", func(t *testing.T) {
+		h := newTestHandler(t, time.Hour)
+
+		calls := 0
+		next := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			if calls == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+
+		call(next, "k1", body)
+		w := call(next, "k1", body)
+
+		if calls != 2 || w.Code != http.StatusOK || w.Header().Get(IdempotencyReplayedHeader) != "" {
+			t.Errorf("после 500: вызовов %d, ответ %d, want 2 и 200 без повтора", calls, w.Code)
+		}
+	})
+
+	t.Run("истекший ключ занимается заново", func(t *testing.T) {
+		h := newTestHandler(t, 10*time.Millisecond)
+
+		calls := 0
+		next := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(http.StatusOK)
+		})
+
+		call(next, "k1", body)
+		time.Sleep(20 * time.Millisecond)
+		call(next, "k1", `{"order":"2377225624","sum":200}`)
+
+		if calls != 2 {
+			t.Errorf("обработчик вызван %d раз, want 2", calls)
+		}
+	})
+
+	t.Run("слишком длинный ключ", func(t *testing.T) {
+		h := newTestHandler(t, time.Hour)
+		next := h.Idempotent(func(w http.ResponseWriter, r *http.Request) {
+			t.Error("обработчик не должен вызываться")
+		})
+
+		w := call(next, strings.Repeat("k", idempotencyKeyMaxLen+1), body)
+		if w.Code != http.StatusBadRequest || problemCode(t, w) != problem.CodeIdempotencyKey {
+			t.Errorf("ответ %d %s, want 400 %s", w.Code, w.Body, problem.CodeIdempotencyKey)
+		}
+	})
+}
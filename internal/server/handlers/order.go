@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	gctx "github.com/gorilla/context"
+
+	"gophermart/internal/server/problem"
+)
+
+func (h *Handler) Order(w http.ResponseWriter, r *http.Request) {
+
+	login := gctx.Get(r, "login").(string)
+	number := chi.URLParam(r, "number")
+
+	detail, err := h.db.GetUserOrder(r.Context(), login, number)
+	if err != nil {
+		problem.Error(w, r, err)
+		return
+	}
+
+	body, err := json.Marshal(detail)
+	if err != nil {
+		problem.Error(w, r, fmt.Errorf("%w: %s", ErrUnmarshal, err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
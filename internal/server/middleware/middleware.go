@@ -4,19 +4,22 @@ import (
 	"github.com/gorilla/context"
 	"gophermart/internal/database"
 	"gophermart/internal/storage"
-	"log"
 	"net/http"
 
 	"gophermart/internal/cookies"
+	"gophermart/internal/server/problem"
+	"gophermart/internal/tokens"
 )
 
 type Middleware struct {
 	cookie *cookies.CookieManager
+	tokens *tokens.Manager
 }
 
-func NewMiddleware(cookie *cookies.CookieManager) *Middleware {
+func NewMiddleware(cookie *cookies.CookieManager, tokens *tokens.Manager) *Middleware {
 	return &Middleware{
 		cookie: cookie,
+		tokens: tokens,
 	}
 }
 
@@ -26,26 +29,27 @@ func (m *Middleware) CookieChecker(next http.Handler) http.Handler {
 		var err error
 		var user storage.User
 
+		user.Login, err = m.tokens.CheckBearer(r)
+		switch err {
+		case nil:
+			context.Set(r, "login", user.Login)
+			next.ServeHTTP(w, r)
+			return
+		case tokens.ErrNoToken:
+		default:
+			problem.Error(w, r, err)
+			return
+		}
+
 		cookieA := r.Cookies()
 
 		user.Login, err = m.cookie.CheckCookie(&user, cookieA)
 		switch {
-		case err == database.ErrConnectToDB:
-			log.Printf("Ошибка: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
 		case err == database.ErrRowDoesntExists:
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		case err == cookies.ErrNoCookie:
-			w.WriteHeader(http.StatusUnauthorized)
-			return
-		case err == cookies.ErrInvalidValue:
-			w.WriteHeader(http.StatusBadRequest)
+			problem.Write(w, r, http.StatusUnauthorized, problem.CodeUnauthorized, cookies.ErrSessionEnded.Error())
 			return
 		case err != nil:
-			log.Printf("Ошибка: %s", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			problem.Error(w, r, err)
 			return
 		default:
 		}
@@ -0,0 +1,166 @@
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"gophermart/internal/client"
+	"gophermart/internal/cookies"
+	"gophermart/internal/database"
+	"gophermart/internal/server/validate"
+	"gophermart/internal/storage"
+	"gophermart/internal/tokens"
+)
+
+const (
+	ContentType = "application/problem+json"
+
+	typePrefix = "urn:gophermart:problem:"
+)
+
+// Коды ошибок стабильны: по ним клиент решает, что показать пользователю.
+const (
+	CodeInternal           = "internal"
+	CodeDatabase           = "database_unavailable"
+	CodeBadRequest         = "bad_request"
+	CodeInvalidJSON        = "invalid_json"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeSessionEnded       = "session_ended"
+	CodeInvalidCookie      = "invalid_cookie"
+	CodeInvalidToken       = "invalid_token"
+	CodeTokenExpired       = "token_expired"
+	CodeLoginTaken         = "login_taken"
+	CodeInvalidOrderNumber = "invalid_order_number"
+	CodeOrderTaken         = "order_taken"
+	CodeNotEnoughMoney     = "not_enough_money"
+	CodeNotFound           = "not_found"
+	CodeConflict           = "conflict"
+	CodeInvalidSignature   = "invalid_signature"
+	CodeValidation         = "validation_failed"
+	CodeBodyTooLarge       = "body_too_large"
+	CodeWithdrawalExists   = "withdrawal_exists"
+	CodeIdempotencyKey     = "invalid_idempotency_key"
+	CodeIdempotencyReuse   = "idempotency_key_reused"
+	CodeIdempotencyPending = "idempotency_in_flight"
+)
+
+// Problem - тело ошибки по RFC 7807.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Code      string `json:"code"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	Errors validate.Errors `json:"errors,omitempty"`
+}
+
+type mapping struct {
+	err    error
+	status int
+	code   string
+}
+
+// mappings задает ответ по умолчанию для известных ошибок. Там, где смысл
+// ошибки зависит от запроса, обработчик вызывает Write со своим кодом.
+var mappings = []mapping{
+	{database.ErrConnectToDB, http.StatusInternalServerError, CodeDatabase},
+	{database.ErrPasswdHash, http.StatusInternalServerError, CodeInternal},
+	{database.ErrNotEnoughMoney, http.StatusPaymentRequired, CodeNotEnoughMoney},
+	{database.ErrNumberFormat, http.StatusUnprocessableEntity, CodeInvalidOrderNumber},
+	{database.ErrRowWasCreatedAnyUser, http.StatusConflict, CodeOrderTaken},
+	{database.ErrRowAlreadyExists, http.StatusConflict, CodeConflict},
+	{database.ErrRowDoesntExists, http.StatusNotFound, CodeNotFound},
+
+	{cookies.ErrNoCookie, http.StatusUnauthorized, CodeUnauthorized},
+	{cookies.ErrSessionEnded, http.StatusUnauthorized, CodeSessionEnded},
+	{cookies.ErrInvalidValue, http.StatusBadRequest, CodeInvalidCookie},
+	{cookies.ErrValueTooLong, http.StatusBadRequest, CodeInvalidCookie},
+
+	{tokens.ErrNoToken, http.StatusUnauthorized, CodeUnauthorized},
+	{tokens.ErrInvalidToken, http.StatusUnauthorized, CodeInvalidToken},
+	{tokens.ErrTokenExpired, http.StatusUnauthorized, CodeTokenExpired},
+
+	{storage.ErrUnknownStatus, http.StatusBadRequest, CodeBadRequest},
+	{storage.ErrIllegalTransition, http.StatusConflict, CodeConflict},
+
+	{client.ErrCallbackDisabled, http.StatusNotFound, CodeNotFound},
+	{client.ErrSignature, http.StatusUnauthorized, CodeInvalidSignature},
+	{client.ErrCallbackFormat, http.StatusBadRequest, CodeBadRequest},
+}
+
+// Error пишет ответ для err по таблице mappings, неизвестные ошибки
+// логируются и отдаются как 500 без подробностей.
+func Error(w http.ResponseWriter, r *http.Request, err error) {
+
+	var fields validate.Errors
+	if errors.As(err, &fields) {
+		write(w, r, Problem{
+			Title:  validate.ErrValidation.Error(),
+			Status: fields.Status(),
+			Code:   CodeValidation,
+			Errors: fields,
+		})
+		return
+	}
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		Write(w, r, http.StatusRequestEntityTooLarge, CodeBodyTooLarge,
+			fmt.Sprintf("тело запроса больше %d байт", tooLarge.Limit))
+		return
+	}
+
+	for _, m := range mappings {
+		if errors.Is(err, m.err) {
+			Write(w, r, m.status, m.code, m.err.Error())
+			return
+		}
+	}
+
+	log.Printf("Ошибка: %s [%s]", err, middleware.GetReqID(r.Context()))
+	Write(w, r, http.StatusInternalServerError, CodeInternal, "внутренняя ошибка сервера")
+}
+
+func Write(w http.ResponseWriter, r *http.Request, status int, code, title string) {
+	WriteDetail(w, r, status, code, title, "")
+}
+
+func WriteDetail(w http.ResponseWriter, r *http.Request, status int, code, title, detail string) {
+	write(w, r, Problem{
+		Title:  title,
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	})
+}
+
+func write(w http.ResponseWriter, r *http.Request, p Problem) {
+
+	p.Type = typePrefix + p.Code
+	p.Instance = r.URL.Path
+	p.RequestID = middleware.GetReqID(r.Context())
+	status := p.Status
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Printf("Ошибка: %s", err)
+		w.WriteHeader(status)
+		return
+	}
+
+	if p.RequestID != "" {
+		w.Header().Set(middleware.RequestIDHeader, p.RequestID)
+	}
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
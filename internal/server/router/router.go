@@ -11,21 +11,32 @@ import (
 func NewRouter(handler *handlers.Handler, middle *middleware.Middleware) chi.Router {
 	r := chi.NewRouter()
 
+	r.Use(mdw.RequestID)
 	r.Use(mdw.Logger)
 
+	r.Get("/api/health", handler.Health)
+	r.Get("/metrics", handler.Metrics)
+
+	r.Post("/internal/accrual/callback", handler.AccrualCallback)
+
 	r.Group(func(r chi.Router) {
 		r.Post("/api/user/register", handler.Register)
 		r.Post("/api/user/login", handler.Login)
+		r.Post("/api/user/token/refresh", handler.RefreshToken)
 	})
 	r.Group(func(r chi.Router) {
 		r.Use(middle.CookieChecker)
 
 		r.Get("/api/user/orders", handler.AllOrder)
+		r.Get("/api/user/orders/{number}", handler.Order)
 		r.Get("/api/user/withdrawals", handler.AllWithdrawals)
 		r.Get("/api/user/balance", handler.Balance)
 
-		r.Post("/api/user/orders", handler.Orders)
-		r.Post("/api/user/balance/withdraw", handler.Withdraw)
+		r.Post("/api/user/orders", handler.Idempotent(handler.Orders))
+		r.Post("/api/user/balance/withdraw", handler.Idempotent(handler.Withdraw))
+
+		r.Post("/api/user/logout", handler.Logout)
+		r.Post("/api/user/logout/all", handler.LogoutAll)
 	})
 
 	return r
@@ -0,0 +1,93 @@
+package validate
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"gophermart/internal/storage"
+)
+
+// NextCursorHeader - заголовок с курсором следующей страницы списка.
+const NextCursorHeader = "X-Next-Cursor"
+
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+const (
+	CodeRange  = "out_of_range"
+	CodeCursor = "invalid_cursor"
+	CodeDate   = "invalid_date"
+	CodeChoice = "invalid_choice"
+)
+
+// Page разбирает limit, cursor, from, to, sort и, если statuses не пуст, status.
+// Даты принимаются в RFC 3339 или как YYYY-MM-DD.
+func (v *Validator) Page(query url.Values, statuses ...string) storage.Page {
+
+	page := storage.Page{Limit: storage.DefaultPageLimit}
+
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > storage.MaxPageLimit {
+			v.add(http.StatusBadRequest, "limit", CodeRange, fmt.Sprintf("целое число от 1 до %d", storage.MaxPageLimit))
+		}
+		page.Limit = n
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		c, err := storage.ParseCursor(s)
+		if err != nil {
+			v.add(http.StatusBadRequest, "cursor", CodeCursor, err.Error())
+		}
+		page.After = &c
+	}
+
+	page.From = v.date(query, "from")
+	page.To = v.date(query, "to")
+	if !page.From.IsZero() && !page.To.IsZero() && !page.From.Before(page.To) {
+		v.add(http.StatusBadRequest, "to", CodeRange, "должна быть позже from")
+	}
+
+	switch query.Get("sort") {
+	case "", SortAsc:
+	case SortDesc:
+		page.Desc = true
+	default:
+		v.add(http.StatusBadRequest, "sort", CodeChoice, "asc или desc")
+	}
+
+	if s := query.Get("status"); s != "" && len(statuses) > 0 {
+		known := false
+		for _, status := range statuses {
+			known = known || s == status
+		}
+		if !known {
+			v.add(http.StatusBadRequest, "status", CodeChoice, fmt.Sprintf("один из %v", statuses))
+		}
+		page.Status = s
+	}
+
+	return page
+}
+
+func (v *Validator) date(query url.Values, field string) time.Time {
+	s := query.Get(field)
+	if s == "" {
+		return time.Time{}
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t
+	}
+
+	v.add(http.StatusBadRequest, field, CodeDate, "дата в формате RFC 3339 или YYYY-MM-DD")
+	return time.Time{}
+}
@@ -0,0 +1,65 @@
+package validate
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"gophermart/internal/storage"
+)
+
+func TestPage(t *testing.T) {
+	cursor := storage.Cursor{At: time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC), ID: 7}
+
+	tests := []struct {
+		query string
+		want  storage.Page
+		code  string
+	}{
+		{"", storage.Page{Limit: storage.DefaultPageLimit}, ""},
+		{"limit=10&sort=desc", storage.Page{Limit: 10, Desc: true}, ""},
+		{"status=PROCESSED", storage.Page{Limit: storage.DefaultPageLimit, Status: storage.StatusProcessed}, ""},
+		{
+			"cursor=" + cursor.Encode(),
+			storage.Page{Limit: storage.DefaultPageLimit, After: &cursor}, "",
+		},
+		{
+			"from=2026-10-01&to=2026-10-18T12:00:00Z",
+			storage.Page{
+				Limit: storage.DefaultPageLimit,
+				From:  time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+				To:    time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC),
+			}, "",
+		},
+		{"limit=0", storage.Page{}, CodeRange},
+		{"limit=1001", storage.Page{}, CodeRange},
+		{"limit=ten", storage.Page{}, CodeRange},
+		{"cursor=abc", storage.Page{}, CodeCursor},
+		{"from=18.10.2026", storage.Page{}, CodeDate},
+		{"from=2026-10-18&to=2026-10-01", storage.Page{}, CodeRange},
+		{"sort=up", storage.Page{}, CodeChoice},
+		{"status=DONE", storage.Page{}, CodeChoice},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+
+		var v Validator
+		page := v.Page(query, storage.StatusNew, storage.StatusProcessing, storage.StatusInvalid, storage.StatusProcessed)
+
+		checkErr(t, "Page("+tt.query+")", v.Err(), tt.code, http.StatusBadRequest)
+		if tt.code != "" {
+			continue
+		}
+
+		if page.Limit != tt.want.Limit || page.Desc != tt.want.Desc || page.Status != tt.want.Status ||
+			!page.From.Equal(tt.want.From) || !page.To.Equal(tt.want.To) {
+			t.Errorf("Page(%s) = %+v, want %+v", tt.query, page, tt.want)
+		}
+		if (page.After == nil) != (tt.want.After == nil) ||
+			(page.After != nil && (!page.After.At.Equal(tt.want.After.At) || page.After.ID != tt.want.After.ID)) {
+			t.Errorf("Page(%s).After = %v, want %v", tt.query, page.After, tt.want.After)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gophermart/internal/storage"
+)
+
+// Ограничения размера тел запросов.
+const (
+	AuthBodyLimit     = 4 << 10
+	OrderBodyLimit    = 1 << 10
+	WithdrawBodyLimit = 1 << 10
+)
+
+const (
+	LoginMinLen    = 3
+	LoginMaxLen    = 64
+	PasswordMinLen = 8
+	// bcrypt учитывает только первые 72 байта пароля
+	PasswordMaxLen = 72
+	OrderMaxLen    = 64
+)
+
+// Коды ошибок полей.
+const (
+	CodeRequired  = "required"
+	CodeTooShort  = "too_short"
+	CodeTooLong   = "too_long"
+	CodeCharset   = "invalid_characters"
+	CodeWeak      = "weak_password"
+	CodeDigits    = "not_digits"
+	CodeLuhn      = "luhn"
+	CodeNumber    = "not_number"
+	CodePositive  = "not_positive"
+	CodePrecision = "too_precise"
+)
+
+var (
+	ErrValidation = errors.New("неверные данные запроса")
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// ошибки формата дают 400, ошибки смысла (номер заказа, сумма) - 422
+	status int
+}
+
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	parts := make([]string, 0, len(e))
+	for _, fe := range e {
+		parts = append(parts, fe.Field+": "+fe.Message)
+	}
+
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(parts, "; "))
+}
+
+func (e Errors) Is(target error) bool {
+	return target == ErrValidation
+}
+
+// Status - 400, если есть хотя бы одна ошибка формата, иначе 422.
+func (e Errors) Status() int {
+	for _, fe := range e {
+		if fe.status == http.StatusBadRequest {
+			return http.StatusBadRequest
+		}
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+// Validator собирает ошибки всех полей, чтобы клиент получил их разом.
+type Validator struct {
+	errs Errors
+}
+
+func (v *Validator) add(status int, field, code, message string) {
+	v.errs = append(v.errs, FieldError{
+		Field:   field,
+		Code:    code,
+		Message: message,
+		status:  status,
+	})
+}
+
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+
+	return v.errs
+}
+
+// Required проверяет только наличие значения, например при входе существующих пользователей.
+func (v *Validator) Required(field, value string) bool {
+	if value == "" {
+		v.add(http.StatusBadRequest, field, CodeRequired, "поле обязательно")
+		return false
+	}
+
+	return true
+}
+
+func (v *Validator) Login(field, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+
+	switch n := utf8.RuneCountInString(value); {
+	case n < LoginMinLen:
+		v.add(http.StatusBadRequest, field, CodeTooShort, fmt.Sprintf("не короче %d символов", LoginMinLen))
+		return
+	case n > LoginMaxLen:
+		v.add(http.StatusBadRequest, field, CodeTooLong, fmt.Sprintf("не длиннее %d символов", LoginMaxLen))
+		return
+	}
+
+	// логин входит в имя куки CookieUser<логин>, а в имени куки допустим не любой символ
+	for _, r := range value {
+		if !isASCIILetter(r) && !('0' <= r && r <= '9') && !strings.ContainsRune("._-", r) {
+			v.add(http.StatusBadRequest, field, CodeCharset, "допустимы латинские буквы, цифры и символы . _ -")
+			return
+		}
+	}
+}
+
+func isASCIILetter(r rune) bool {
+	return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z')
+}
+
+// Password требует длину от PasswordMinLen и хотя бы два класса символов
+// из четырех: строчные, заглавные, цифры, прочие.
+func (v *Validator) Password(field, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+
+	if utf8.RuneCountInString(value) < PasswordMinLen {
+		v.add(http.StatusBadRequest, field, CodeTooShort, fmt.Sprintf("не короче %d символов", PasswordMinLen))
+		return
+	}
+	if len(value) > PasswordMaxLen {
+		v.add(http.StatusBadRequest, field, CodeTooLong, fmt.Sprintf("не длиннее %d байт", PasswordMaxLen))
+		return
+	}
+
+	var lower, upper, digit, other int
+	for _, r := range value {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			other = 1
+		}
+	}
+
+	if lower+upper+digit+other < 2 {
+		v.add(http.StatusBadRequest, field, CodeWeak, "нужны символы хотя бы двух видов: строчные, заглавные, цифры, прочие")
+	}
+}
+
+// OrderNumber проверяет номер произвольной длины как строку, без перевода в число.
+func (v *Validator) OrderNumber(field, value string) {
+	if !v.Required(field, value) {
+		return
+	}
+
+	if len(value) > OrderMaxLen {
+		v.add(http.StatusUnprocessableEntity, field, CodeTooLong, fmt.Sprintf("не длиннее %d цифр", OrderMaxLen))
+		return
+	}
+
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			v.add(http.StatusUnprocessableEntity, field, CodeDigits, "номер заказа состоит только из цифр")
+			return
+		}
+	}
+
+	if !Luhn(value) {
+		v.add(http.StatusUnprocessableEntity, field, CodeLuhn, "номер заказа не проходит проверку по алгоритму Луна")
+	}
+}
+
+// Sum разбирает положительную сумму с точностью не больше двух знаков после запятой.
+func (v *Validator) Sum(field string, value json.Number) storage.Points {
+	if !v.Required(field, value.String()) {
+		return 0
+	}
+
+	r, ok := new(big.Rat).SetString(value.String())
+	if !ok {
+		v.add(http.StatusBadRequest, field, CodeNumber, "сумма должна быть числом")
+		return 0
+	}
+
+	if r.Sign() <= 0 {
+		v.add(http.StatusUnprocessableEntity, field, CodePositive, "сумма должна быть больше нуля")
+		return 0
+	}
+
+	if !new(big.Rat).Mul(r, big.NewRat(100, 1)).IsInt() {
+		v.add(http.StatusUnprocessableEntity, field, CodePrecision, "не больше двух знаков после запятой")
+		return 0
+	}
+
+	sum, err := storage.ParsePoints(value.String())
+	if err != nil || sum > storage.MaxPoints {
+		v.add(http.StatusUnprocessableEntity, field, CodeRange,
+			fmt.Sprintf("сумма не больше %s", storage.MaxPoints))
+		return 0
+	}
+
+	return sum
+}
+
+func Luhn(number string) bool {
+	sum := 0
+	double := false
+
+	for i := len(number) - 1; i >= 0; i-- {
+		d := int(number[i] - '0')
+		if d < 0 || d > 9 {
+			return false
+		}
+
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		double = !double
+	}
+
+	return number != "" && sum%10 == 0
+}
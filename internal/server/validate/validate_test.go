@@ -0,0 +1,142 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"gophermart/internal/storage"
+)
+
+func TestLuhn(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"0", true},
+		{"18", true},
+		{"79927398713", true},
+		{"79927398710", false},
+		{"12345678903", true},
+		{"12345678904", false},
+		{"2377225624", true},
+		{"4561261212345467", true},
+		{"4561261212345464", false},
+		{"1234567890123456789012345678901234567898", true},
+		{"12a4", false},
+		{"-18", false},
+	}
+
+	for _, tt := range tests {
+		if got := Luhn(tt.number); got != tt.want {
+			t.Errorf("Luhn(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestSum(t *testing.T) {
+	tests := []struct {
+		in     json.Number
+		want   storage.Points
+		code   string
+		status int
+	}{
+		{"100", 10000, "", 0},
+		{"0.01", 1, "", 0},
+		{"751.5", 75150, "", 0},
+		{"999999999999.99", storage.MaxPoints, "", 0},
+		{"", 0, CodeRequired, http.StatusBadRequest},
+		{"abc", 0, CodeNumber, http.StatusBadRequest},
+		{"0", 0, CodePositive, http.StatusUnprocessableEntity},
+		{"-5", 0, CodePositive, http.StatusUnprocessableEntity},
+		{"1.001", 0, CodePrecision, http.StatusUnprocessableEntity},
+		{"1000000000000", 0, CodeRange, http.StatusUnprocessableEntity},
+		{"1e30", 0, CodeRange, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		var v Validator
+
+		got := v.Sum("sum", tt.in)
+		if got != tt.want {
+			t.Errorf("Sum(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+
+		checkErr(t, "Sum("+string(tt.in)+")", v.Err(), tt.code, tt.status)
+	}
+}
+
+func TestOrderNumber(t *testing.T) {
+	tests := []struct {
+		in   string
+		code string
+	}{
+		{"12345678903", ""},
+		{"", CodeRequired},
+		{"12345678904", CodeLuhn},
+		{"1234-5678", CodeDigits},
+		{strings.Repeat("1", OrderMaxLen+1), CodeTooLong},
+	}
+
+	for _, tt := range tests {
+		var v Validator
+
+		v.OrderNumber("number", tt.in)
+
+		status := http.StatusUnprocessableEntity
+		if tt.code == CodeRequired {
+			status = http.StatusBadRequest
+		}
+		checkErr(t, "OrderNumber("+tt.in+")", v.Err(), tt.code, status)
+	}
+}
+
+// checkErr ожидает ровно одну ошибку поля с кодом code или ни одной, если code пуст.
+func checkErr(t *testing.T, name string, err error, code string, status int) {
+	t.Helper()
+
+	if code == "" {
+		if err != nil {
+			t.Errorf("%s: неожиданная ошибка %v", name, err)
+		}
+		return
+	}
+
+	var fields Errors
+	if !errors.As(err, &fields) || !errors.Is(err, ErrValidation) {
+		t.Errorf("%s: error = %v, want %s", name, err, code)
+		return
+	}
+	if len(fields) != 1 || fields[0].Code != code {
+		t.Errorf("%s: errors = %v, want %s", name, fields, code)
+	}
+	if fields.Status() != status {
+		t.Errorf("%s: status = %d, want %d", name, fields.Status(), status)
+	}
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		in   string
+		code string
+	}{
+		{"user1", ""},
+		{"Ivan.Petrov_2-x", ""},
+		{"", CodeRequired},
+		{"ab", CodeTooShort},
+		{strings.Repeat("a", LoginMaxLen+1), CodeTooLong},
+		{"a@b.c", CodeCharset},
+		{"Иван", CodeCharset},
+		{"user name", CodeCharset},
+		{"user;x", CodeCharset},
+	}
+
+	for _, tt := range tests {
+		var v Validator
+
+		v.Login("login", tt.in)
+		checkErr(t, "Login("+tt.in+")", v.Err(), tt.code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,99 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"gophermart/internal/storage"
+)
+
+const (
+	StoreDB     = "db"
+	StoreMemory = "memory"
+)
+
+var (
+	ErrNotFound = errors.New("сессия не найдена или истекла")
+)
+
+type Store interface {
+	CreateSession(ctx context.Context, session *storage.Session) error
+	GetSession(ctx context.Context, id string) (*storage.Session, error)
+	TouchSession(ctx context.Context, id string, expiresAt time.Time) error
+	DeleteSession(ctx context.Context, id string) error
+	DeleteUserSessions(ctx context.Context, login string) error
+}
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	sessions map[string]storage.Session
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions: make(map[string]storage.Session),
+	}
+}
+
+func (m *MemoryStore) CreateSession(_ context.Context, session *storage.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, s := range m.sessions {
+		if s.ExpiresAt.Before(now) {
+			delete(m.sessions, id)
+		}
+	}
+
+	m.sessions[session.ID] = *session
+	return nil
+}
+
+func (m *MemoryStore) GetSession(_ context.Context, id string) (*storage.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || s.ExpiresAt.Before(time.Now()) {
+		return nil, ErrNotFound
+	}
+
+	return &s, nil
+}
+
+func (m *MemoryStore) TouchSession(_ context.Context, id string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	s.ExpiresAt = expiresAt
+	m.sessions[id] = s
+	return nil
+}
+
+func (m *MemoryStore) DeleteSession(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *MemoryStore) DeleteUserSessions(_ context.Context, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, s := range m.sessions {
+		if s.User == login {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
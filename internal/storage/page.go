@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 100
+	MaxPageLimit     = 1000
+)
+
+var (
+	ErrCursorFormat = errors.New("неверный курсор")
+)
+
+// Cursor указывает на последнюю выданную запись: следующая страница
+// начинается строго после пары (At, ID) в выбранном порядке сортировки.
+type Cursor struct {
+	At time.Time
+	ID int
+}
+
+func (c Cursor) Encode() string {
+	raw := c.At.UTC().Format(time.RFC3339Nano) + "|" + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrCursorFormat
+	}
+
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return Cursor{}, ErrCursorFormat
+	}
+
+	var c Cursor
+
+	if c.At, err = time.Parse(time.RFC3339Nano, at); err != nil {
+		return Cursor{}, ErrCursorFormat
+	}
+	if c.ID, err = strconv.Atoi(id); err != nil {
+		return Cursor{}, ErrCursorFormat
+	}
+
+	return c, nil
+}
+
+// Page - параметры выборки списка. From включается в диапазон, To - нет.
+type Page struct {
+	Limit  int
+	After  *Cursor
+	Status string
+	From   time.Time
+	To     time.Time
+	Desc   bool
+}
+
+// Contains проверяет фильтры по дате и положение относительно курсора.
+func (p Page) Contains(at time.Time, id int) bool {
+	if !p.From.IsZero() && at.Before(p.From) {
+		return false
+	}
+	if !p.To.IsZero() && !at.Before(p.To) {
+		return false
+	}
+	if p.After == nil {
+		return true
+	}
+
+	if p.Desc {
+		return at.Before(p.After.At) || (at.Equal(p.After.At) && id < p.After.ID)
+	}
+	return at.After(p.After.At) || (at.Equal(p.After.At) && id > p.After.ID)
+}
+
+// Less задает порядок выдачи: по времени, при равенстве - по id.
+func (p Page) Less(at1 time.Time, id1 int, at2 time.Time, id2 int) bool {
+	if !at1.Equal(at2) {
+		return at1.Before(at2) != p.Desc
+	}
+	return (id1 < id2) != p.Desc
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	zone := time.FixedZone("MSK", 3*60*60)
+
+	tests := []Cursor{
+		{At: time.Date(2026, 10, 18, 9, 17, 30, 0, time.UTC), ID: 1},
+		{At: time.Date(2026, 10, 18, 12, 17, 30, 123456789, zone), ID: 42},
+		{At: time.Unix(0, 0), ID: 0},
+	}
+
+	for _, c := range tests {
+		got, err := ParseCursor(c.Encode())
+		if err != nil {
+			t.Errorf("ParseCursor(%v): %v", c, err)
+			continue
+		}
+		if !got.At.Equal(c.At) || got.ID != c.ID {
+			t.Errorf("ParseCursor(Encode(%v)) = %v", c, got)
+		}
+	}
+}
+
+func TestParseCursorInvalid(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []string{
+		"!!!",
+		encode("2026-10-18T09:17:30Z"),
+		encode("вчера|1"),
+		encode("2026-10-18T09:17:30Z|x"),
+	}
+
+	for _, s := range tests {
+		if _, err := ParseCursor(s); !errors.Is(err, ErrCursorFormat) {
+			t.Errorf("ParseCursor(%q) error = %v, want %v", s, err, ErrCursorFormat)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+var (
+	ErrPointsFormat = errors.New("неверный формат количества баллов")
+)
+
+// Points - количество баллов в сотых долях, чтобы суммы не накапливали ошибку округления.
+type Points int64
+
+const pointsScale = 100
+
+// MaxPoints - наибольшая сумма, которая помещается в колонки NUMERIC(14, 2).
+const MaxPoints Points = 1e14 - 1
+
+func NewPoints(units, cents int64) Points {
+	return Points(units*pointsScale + cents)
+}
+
+func ParsePoints(s string) (Points, error) {
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, ErrPointsFormat
+	}
+
+	r.Mul(r, big.NewRat(pointsScale, 1))
+
+	num, den := r.Num(), r.Denom()
+	quo, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+
+	if rem.Sign() != 0 && new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		quo.Add(quo, big.NewInt(int64(num.Sign())))
+	}
+
+	if !quo.IsInt64() {
+		return 0, ErrPointsFormat
+	}
+
+	return Points(quo.Int64()), nil
+}
+
+// Percent возвращает pct процентов от p, pct тоже задан в сотых долях.
+func (p Points) Percent(pct Points) Points {
+	r := new(big.Rat).SetFrac(
+		new(big.Int).Mul(big.NewInt(int64(p)), big.NewInt(int64(pct))),
+		big.NewInt(100*pointsScale*pointsScale),
+	)
+
+	v, err := ParsePoints(r.RatString())
+	if err != nil {
+		return 0
+	}
+
+	return v
+}
+
+func (p Points) String() string {
+	sign := ""
+	v := int64(p)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	return fmt.Sprintf("%s%d.%02d", sign, v/pointsScale, v%pointsScale)
+}
+
+func (p Points) Float64() float64 {
+	return float64(p) / pointsScale
+}
+
+func (p Points) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+func (p *Points) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	v, err := ParsePoints(s)
+	if err != nil {
+		return err
+	}
+
+	*p = v
+	return nil
+}
+
+func (p Points) Value() (driver.Value, error) {
+	return p.String(), nil
+}
+
+func (p *Points) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*p = 0
+	case int64:
+		*p = Points(v * pointsScale)
+	case float64:
+		*p = Points(math.Round(v * pointsScale))
+	case []byte:
+		return p.Scan(string(v))
+	case string:
+		parsed, err := ParsePoints(v)
+		if err != nil {
+			return err
+		}
+		*p = parsed
+	default:
+		return fmt.Errorf("%w: %T", ErrPointsFormat, src)
+	}
+
+	return nil
+}
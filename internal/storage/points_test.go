@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParsePoints(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Points
+		err  error
+	}{
+		{"0", 0, nil},
+		{"1", 100, nil},
+		{"12.34", 1234, nil},
+		{"0.1", 10, nil},
+		{"1.004", 100, nil},
+		{"1.005", 101, nil},
+		{"-1.005", -101, nil},
+		{"729.98", 72998, nil},
+		{"1/3", 33, nil},
+		{"abc", 0, ErrPointsFormat},
+		{"", 0, ErrPointsFormat},
+		{"100000000000000000000", 0, ErrPointsFormat},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePoints(tt.in)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParsePoints(%q) error = %v, want %v", tt.in, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePoints(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPointsPercent(t *testing.T) {
+	tests := []struct {
+		amount, pct, want string
+	}{
+		{"100", "5", "5.00"},
+		{"1000", "12.5", "125.00"},
+		{"1.01", "50", "0.51"},
+		{"1.01", "49.5", "0.50"},
+		{"0.01", "10", "0.00"},
+		{"3.33", "33.33", "1.11"},
+		{"100", "0", "0.00"},
+	}
+
+	for _, tt := range tests {
+		amount, _ := ParsePoints(tt.amount)
+		pct, _ := ParsePoints(tt.pct)
+
+		if got := amount.Percent(pct).String(); got != tt.want {
+			t.Errorf("%s%% от %s = %s, want %s", tt.pct, tt.amount, got, tt.want)
+		}
+	}
+}
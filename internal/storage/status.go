@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	StatusNew        = "NEW"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+
+	AccrualRegistered = "REGISTERED"
+	AccrualProcessing = "PROCESSING"
+	AccrualInvalid    = "INVALID"
+	AccrualProcessed  = "PROCESSED"
+)
+
+var (
+	ErrUnknownStatus     = errors.New("неизвестный статус заказа")
+	ErrIllegalTransition = errors.New("недопустимая смена статуса заказа")
+)
+
+var transitions = map[string][]string{
+	StatusNew:        {StatusProcessing, StatusInvalid, StatusProcessed},
+	StatusProcessing: {StatusInvalid, StatusProcessed},
+}
+
+// FromAccrual переводит статус системы расчета в статус заказа gophermart.
+func FromAccrual(status string) (string, error) {
+	switch status {
+	case AccrualRegistered, AccrualProcessing:
+		return StatusProcessing, nil
+	case AccrualInvalid:
+		return StatusInvalid, nil
+	case AccrualProcessed:
+		return StatusProcessed, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownStatus, status)
+	}
+}
+
+func IsTerminal(status string) bool {
+	return status == StatusInvalid || status == StatusProcessed
+}
+
+// CheckTransition разрешает повтор текущего статуса, чтобы повторные ответы
+// системы расчета не считались ошибкой, но не меняли данных.
+func CheckTransition(from, to string) (changed bool, err error) {
+	if from == to {
+		return false, nil
+	}
+
+	for _, next := range transitions[from] {
+		if next == to {
+			return true, nil
+		}
+	}
+
+	return false, fmt.Errorf("%w: %s -> %s", ErrIllegalTransition, from, to)
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFromAccrual(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{AccrualRegistered, StatusProcessing, nil},
+		{AccrualProcessing, StatusProcessing, nil},
+		{AccrualInvalid, StatusInvalid, nil},
+		{AccrualProcessed, StatusProcessed, nil},
+		{"NEW", "", ErrUnknownStatus},
+		{"", "", ErrUnknownStatus},
+	}
+
+	for _, tt := range tests {
+		got, err := FromAccrual(tt.in)
+		if !errors.Is(err, tt.err) || got != tt.want {
+			t.Errorf("FromAccrual(%q) = %q, %v, want %q, %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestCheckTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		changed  bool
+		err      error
+	}{
+		{StatusNew, StatusNew, false, nil},
+		{StatusNew, StatusProcessing, true, nil},
+		{StatusNew, StatusInvalid, true, nil},
+		{StatusNew, StatusProcessed, true, nil},
+		{StatusProcessing, StatusProcessing, false, nil},
+		{StatusProcessing, StatusProcessed, true, nil},
+		{StatusProcessing, StatusInvalid, true, nil},
+		{StatusProcessing, StatusNew, false, ErrIllegalTransition},
+		{StatusProcessed, StatusProcessed, false, nil},
+		{StatusProcessed, StatusProcessing, false, ErrIllegalTransition},
+		{StatusProcessed, StatusInvalid, false, ErrIllegalTransition},
+		{StatusInvalid, StatusProcessed, false, ErrIllegalTransition},
+	}
+
+	for _, tt := range tests {
+		changed, err := CheckTransition(tt.from, tt.to)
+		if !errors.Is(err, tt.err) || changed != tt.changed {
+			t.Errorf("CheckTransition(%s, %s) = %v, %v, want %v, %v", tt.from, tt.to, changed, err, tt.changed, tt.err)
+		}
+	}
+}
@@ -1,25 +1,81 @@
 package storage
 
+import "time"
+
 type User struct {
-	Login    string  `json:"login"`
-	Passwd   string  `json:"password"`
-	Balance  float64 `json:"current"`
-	Withdraw float64 `json:"withdrawn"`
+	Login    string `json:"login"`
+	Passwd   string `json:"password"`
+	Balance  Points `json:"current"`
+	Withdraw Points `json:"withdrawn"`
 }
 
 type Order struct {
 	ID         int
-	User       string  `json:"user,omitempty"`
-	Number     string  `json:"number,omitempty"`
-	Status     string  `json:"status,omitempty"`
-	Accrual    float64 `json:"accrual,omitempty"`
-	UploadedAt string  `json:"uploaded_at,omitempty"`
+	User       string `json:"user,omitempty"`
+	Number     string `json:"number,omitempty"`
+	Status     string `json:"status,omitempty"`
+	Accrual    Points `json:"accrual,omitempty"`
+	UploadedAt string `json:"uploaded_at,omitempty"`
+	Amount     Points `json:"amount,omitempty"`
+
+	Attempts  int    `json:"-"`
+	LastError string `json:"-"`
+	Stalled   bool   `json:"-"`
 }
 
 type Withdraw struct {
 	ID          int
-	User        string  `json:"user"`
-	NumberOrder string  `json:"order"`
-	Sum         float64 `json:"sum"`
-	ProcessedAt string  `json:"processed_at"`
+	User        string `json:"user"`
+	NumberOrder string `json:"order"`
+	Sum         Points `json:"sum"`
+	ProcessedAt string `json:"processed_at"`
+}
+
+const (
+	LedgerAccrual    = "accrual"
+	LedgerWithdrawal = "withdrawal"
+	LedgerReversal   = "reversal"
+	LedgerAdjustment = "adjustment"
+)
+
+type LedgerEntry struct {
+	ID        int    `json:"id"`
+	User      string `json:"user"`
+	Kind      string `json:"kind"`
+	Amount    Points `json:"amount"`
+	Order     string `json:"order,omitempty"`
+	RefID     int    `json:"ref_id,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+type OrderEvent struct {
+	At      string `json:"at"`
+	From    string `json:"from,omitempty"`
+	To      string `json:"to"`
+	Accrual Points `json:"accrual,omitempty"`
+}
+
+type OrderDetail struct {
+	Order
+	History []OrderEvent `json:"history"`
+}
+
+// IdempotencyKey - ответ на запрос с заголовком Idempotency-Key.
+// Status 0 означает, что исходный запрос еще выполняется.
+type IdempotencyKey struct {
+	User        string
+	Key         string
+	RequestHash string
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+type Session struct {
+	ID        string
+	User      string
+	CreatedAt time.Time
+	ExpiresAt time.Time
 }
@@ -0,0 +1,243 @@
+package tokens
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/session"
+	"gophermart/internal/storage"
+)
+
+const (
+	TypeAccess  = "access"
+	TypeRefresh = "refresh"
+
+	bearerPrefix = "Bearer "
+)
+
+var (
+	ErrNoToken      = errors.New("нет токена")
+	ErrInvalidToken = errors.New("неверный токен")
+	ErrTokenExpired = errors.New("срок действия токена истек")
+)
+
+var header = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type Claims struct {
+	Subject   string `json:"sub"`
+	Type      string `json:"typ"`
+	SessionID string `json:"sid"`
+	ID        string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+type Pair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// Manager выпускает подписанные HS256 токены. Оба токена ссылаются на сессию,
+// поэтому выход из системы отзывает их так же, как куки.
+type Manager struct {
+	secret     []byte
+	accessTTL  time.Duration
+	refreshTTL time.Duration
+	sessions   session.Store
+}
+
+// NewManager без JWTSecret подписывает токены случайным ключом: после перезапуска
+// или на другом экземпляре сервиса выданные токены перестают приниматься.
+func NewManager(cfg *config.Config, sessions session.Store) (*Manager, error) {
+
+	secret := []byte(cfg.JWTSecret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		log.Printf("ключ подписи токенов не задан (-j, JWT_SECRET), используется случайный ключ процесса")
+	}
+
+	return &Manager{
+		secret:     secret,
+		accessTTL:  cfg.AccessTokenTTL,
+		refreshTTL: cfg.RefreshTokenTTL,
+		sessions:   sessions,
+	}, nil
+}
+
+func (m *Manager) Issue(ctx context.Context, login string) (*Pair, error) {
+
+	sid, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = m.sessions.CreateSession(ctx, &storage.Session{
+		ID:        sid,
+		User:      login,
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.refreshTTL),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	access, err := m.sign(login, TypeAccess, sid, now, m.accessTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, err := m.sign(login, TypeRefresh, sid, now, m.refreshTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pair{
+		AccessToken:  access,
+		RefreshToken: refresh,
+		TokenType:    strings.TrimSpace(bearerPrefix),
+		ExpiresIn:    int64(m.accessTTL.Seconds()),
+	}, nil
+}
+
+func (m *Manager) Refresh(ctx context.Context, refreshToken string) (*Pair, error) {
+
+	claims, err := m.verify(ctx, refreshToken, TypeRefresh)
+	if err != nil {
+		return nil, err
+	}
+
+	// refresh токен одноразовый: старая сессия закрывается, выпускается новая пара
+	err = m.sessions.DeleteSession(ctx, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.Issue(ctx, claims.Subject)
+}
+
+func (m *Manager) CheckBearer(r *http.Request) (string, error) {
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, bearerPrefix) {
+		return "", ErrNoToken
+	}
+
+	claims, err := m.verify(r.Context(), strings.TrimPrefix(auth, bearerPrefix), TypeAccess)
+	if err != nil {
+		return "", err
+	}
+
+	return claims.Subject, nil
+}
+
+func (m *Manager) Revoke(r *http.Request) error {
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, bearerPrefix) {
+		return ErrNoToken
+	}
+
+	claims, err := m.verify(r.Context(), strings.TrimPrefix(auth, bearerPrefix), TypeAccess)
+	if err != nil {
+		return err
+	}
+
+	return m.sessions.DeleteSession(r.Context(), claims.SessionID)
+}
+
+func (m *Manager) sign(login, typ, sid string, now time.Time, ttl time.Duration) (string, error) {
+
+	jti, err := randomID()
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(Claims{
+		Subject:   login,
+		Type:      typ,
+		SessionID: sid,
+		ID:        jti,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return unsigned + "." + m.signature(unsigned), nil
+}
+
+func (m *Manager) verify(ctx context.Context, token, typ string) (*Claims, error) {
+
+	var claims Claims
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != header {
+		return nil, ErrInvalidToken
+	}
+
+	expected := m.signature(parts[0] + "." + parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Type != typ {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+
+	s, err := m.sessions.GetSession(ctx, claims.SessionID)
+	switch {
+	case errors.Is(err, session.ErrNotFound):
+		return nil, ErrTokenExpired
+	case err != nil:
+		return nil, err
+	case s.User != claims.Subject:
+		return nil, ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+func (m *Manager) signature(unsigned string) string {
+	mac := hmac.New(sha256.New, m.secret)
+	mac.Write([]byte(unsigned))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,151 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gophermart/internal/config"
+	"gophermart/internal/session"
+	"gophermart/internal/storage"
+)
+
+func newTestManager(t *testing.T) (*Manager, *session.MemoryStore) {
+	t.Helper()
+
+	sessions := session.NewMemoryStore()
+
+	m, err := NewManager(&config.Config{
+		JWTSecret:       "test-secret",
+		AccessTokenTTL:  time.Minute,
+		RefreshTokenTTL: time.Hour,
+	}, sessions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m, sessions
+}
+
+func bearer(m *Manager, token string) (string, error) {
+	r := httptest.NewRequest("GET", "/api/user/balance", nil)
+	r.Header.Set("Authorization", bearerPrefix+token)
+
+	return m.CheckBearer(r)
+}
+
+func TestCheckBearer(t *testing.T) {
+	m, sessions := newTestManager(t)
+	ctx := context.Background()
+
+	pair, err := m.Issue(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	sid := "expired-session"
+	if err = sessions.CreateSession(ctx, &storage.Session{ID: sid, User: "user1", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := m.sign("user1", TypeAccess, sid, now.Add(-time.Hour), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	foreign, _ := newTestManager(t)
+	foreign.secret = []byte("other-secret")
+	forged, err := foreign.sign("user1", TypeAccess, sid, now, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(pair.AccessToken, ".")
+	otherPayload := strings.Split(forged, ".")[1]
+
+	tests := []struct {
+		name  string
+		token string
+		login string
+		err   error
+	}{
+		{"действующий токен доступа", pair.AccessToken, "user1", nil},
+		{"refresh вместо токена доступа", pair.RefreshToken, "", ErrInvalidToken},
+		{"подпись другим ключом", forged, "", ErrInvalidToken},
+		{"подмененные данные", parts[0] + "." + otherPayload + "." + parts[2], "", ErrInvalidToken},
+		{"испорченная подпись", pair.AccessToken + "x", "", ErrInvalidToken},
+		{"не JWT", "abc", "", ErrInvalidToken},
+		{"истекший токен", expired, "", ErrTokenExpired},
+	}
+
+	for _, tt := range tests {
+		login, err := bearer(m, tt.token)
+		if login != tt.login || !errors.Is(err, tt.err) {
+			t.Errorf("%s: %q, %v, want %q, %v", tt.name, login, err, tt.login, tt.err)
+		}
+	}
+
+	r := httptest.NewRequest("GET", "/api/user/balance", nil)
+	if _, err = m.CheckBearer(r); !errors.Is(err, ErrNoToken) {
+		t.Errorf("без заголовка: %v, want %v", err, ErrNoToken)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	pair, err := m.Issue(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest("POST", "/api/user/logout", nil)
+	r.Header.Set("Authorization", bearerPrefix+pair.AccessToken)
+
+	if err = m.Revoke(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// отозванная сессия закрывает и токен доступа, и refresh токен
+	if _, err = bearer(m, pair.AccessToken); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("токен доступа после выхода: %v, want %v", err, ErrTokenExpired)
+	}
+	if _, err = m.Refresh(ctx, pair.RefreshToken); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("refresh после выхода: %v, want %v", err, ErrTokenExpired)
+	}
+}
+
+func TestRefreshOneTime(t *testing.T) {
+	m, _ := newTestManager(t)
+	ctx := context.Background()
+
+	first, err := m.Issue(ctx, "user1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Refresh(ctx, first.AccessToken); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("токен доступа вместо refresh: %v, want %v", err, ErrInvalidToken)
+	}
+
+	second, err := m.Refresh(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = m.Refresh(ctx, first.RefreshToken); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("повторный refresh: %v, want %v", err, ErrTokenExpired)
+	}
+
+	// старая пара закрыта вместе с сессией, новая работает
+	if _, err = bearer(m, first.AccessToken); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("старый токен доступа: %v, want %v", err, ErrTokenExpired)
+	}
+	if login, err := bearer(m, second.AccessToken); err != nil || login != "user1" {
+		t.Errorf("новый токен доступа: %q, %v", login, err)
+	}
+}